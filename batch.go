@@ -0,0 +1,362 @@
+package orm
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+func getPkFieldByType(t reflect.Type) (reflect.StructField, bool) {
+	for k := 0; k < t.NumField(); k++ {
+		ft := t.Field(k)
+		if ft.Tag.Get("pk") == "true" {
+			return ft, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// findInBatches pages through the rows of s's element table matching where
+// by keyset pagination on the primary key.
+func findInBatches(tdx Tdx, s interface{}, batchSize int, where string, args []interface{}, fn func(batch interface{}) error) error {
+	if batchSize <= 0 {
+		return errors.New("batch size should be positive")
+	}
+	t, err := toSliceType(s)
+	if err != nil {
+		return err
+	}
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return errors.New("FindInBatches needs a pointer to a slice of struct pointers")
+	}
+	et := t.Elem()
+	pkField, ok := getPkFieldByType(et)
+	if !ok {
+		return errors.New(getTableName(reflect.New(et).Interface()) + " does not have primary key")
+	}
+	pk := getPkColumnByType(et)
+	table := GetMapTable(getTableName(reflect.New(et).Interface()))
+
+	sliceValue := reflect.ValueOf(s).Elem()
+	if scoped := notDeleted(tdx, et); scoped != "" {
+		if where == "" {
+			where = strings.TrimPrefix(scoped, " and ")
+		} else {
+			where = "(" + where + ")" + scoped
+		}
+	}
+	var last interface{}
+	for {
+		cond := where
+		queryArgs := append([]interface{}{}, args...)
+		if last != nil {
+			if cond != "" {
+				cond = "(" + cond + ") and "
+			}
+			cond += pk + " > ?"
+			queryArgs = append(queryArgs, last)
+		}
+		query := "select * from " + table
+		if cond != "" {
+			query += " where " + cond
+		}
+		query += fmt.Sprintf(" order by %s limit %d", pk, batchSize)
+
+		sliceValue.Set(reflect.MakeSlice(sliceValue.Type(), 0, batchSize))
+		if err := selectMany(tdx, s, query, queryArgs...); err != nil {
+			return err
+		}
+		n := sliceValue.Len()
+		if n == 0 {
+			return nil
+		}
+		if err := fn(s); err != nil {
+			return err
+		}
+		if n < batchSize {
+			return nil
+		}
+		last = sliceValue.Index(n - 1).Elem().FieldByIndex(pkField.Index).Interface()
+	}
+}
+
+// FindInBatches loads the rows matching where, batchSize at a time ordered
+// by primary key, into s, a pointer to a slice of struct pointers, and calls
+// fn after every batch. A non-nil error from fn stops the paging and is returned.
+func (o *ORM) FindInBatches(s interface{}, batchSize int, where string, args []interface{}, fn func(batch interface{}) error) error {
+	tdx, span := o.startOp("FindInBatches", "", where)
+	err := findInBatches(tdx, s, batchSize, where, args, fn)
+	span.end(-1, err)
+	return err
+}
+
+func (o *ORMTran) FindInBatches(s interface{}, batchSize int, where string, args []interface{}, fn func(batch interface{}) error) error {
+	tdx, span := o.startOp("FindInBatches", "", where)
+	err := findInBatches(tdx, s, batchSize, where, args, fn)
+	span.end(-1, err)
+	return err
+}
+
+const (
+	// MaxPlaceholders is the number of placeholders MySQL accepts in one prepared statement.
+	MaxPlaceholders = 65535
+	// DefaultBatchMaxBytes keeps a batch insert below MySQL's default max_allowed_packet of 4MB.
+	DefaultBatchMaxBytes = 4 << 20
+)
+
+// BatchOptions controls how InsertBatch splits its input into statements.
+type BatchOptions struct {
+	// MaxRows bounds the rows per statement, 0 only applies the placeholder limit.
+	MaxRows int
+	// MaxBytes bounds the estimated size of the values per statement, 0 means DefaultBatchMaxBytes.
+	MaxBytes int
+	// InTransaction runs all statements of an ORM.InsertBatch call in one
+	// transaction when the input needs more than one. ORMTran always does.
+	InTransaction bool
+	// AutoIncrementIncrement is the server's auto_increment_increment, 0
+	// asks the server once.
+	AutoIncrementIncrement int64
+}
+
+// SetBatchOptions changes how InsertBatch splits its input.
+func (o *ORM) SetBatchOptions(opts BatchOptions) {
+	o.hooks.batch = opts
+}
+
+// insertFieldIndexes returns the indexes of the fields of t an insert writes.
+func insertFieldIndexes(t reflect.Type) []int {
+	ret := make([]int, 0, t.NumField())
+	for k := 0; k < t.NumField(); k++ {
+		ft := t.Field(k)
+		if ft.Tag.Get("pk") == "true" && ft.Tag.Get("ai") == "true" {
+			continue
+		}
+		if ft.Tag.Get("ignore") == "true" || ft.Tag.Get("or") != "" {
+			continue
+		}
+		ret = append(ret, k)
+	}
+	return ret
+}
+
+// estimateArgSize roughly estimates the bytes v takes in a statement.
+func estimateArgSize(v reflect.Value) int {
+	switch v.Kind() {
+	case reflect.String:
+		return len(v.String()) + 8
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Len() + 8
+		}
+	case reflect.Struct:
+		if valuer, ok := v.Addr().Interface().(driver.Valuer); ok {
+			dv, err := valuer.Value()
+			if err == nil {
+				switch x := dv.(type) {
+				case string:
+					return len(x) + 8
+				case []byte:
+					return len(x) + 8
+				}
+			}
+		}
+	}
+	return 16
+}
+
+// splitBatch cuts s into chunks each fitting in one insert statement.
+func splitBatch(s []interface{}, opts BatchOptions) [][]interface{} {
+	if len(s) == 0 {
+		return nil
+	}
+	t := reflect.TypeOf(s[0]).Elem()
+	fields := insertFieldIndexes(t)
+	maxRows := len(s)
+	if len(fields) > 0 && MaxPlaceholders/len(fields) < maxRows {
+		maxRows = MaxPlaceholders / len(fields)
+	}
+	if opts.MaxRows > 0 && opts.MaxRows < maxRows {
+		maxRows = opts.MaxRows
+	}
+	maxBytes := opts.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultBatchMaxBytes
+	}
+
+	chunks := make([][]interface{}, 0, len(s)/maxRows+1)
+	start, size := 0, 0
+	for i, record := range s {
+		v := reflect.ValueOf(record).Elem()
+		rowSize := 4
+		if v.Type() == t {
+			for _, k := range fields {
+				rowSize += estimateArgSize(v.Field(k)) + 2
+			}
+		}
+		if i > start && (i-start >= maxRows || size+rowSize > maxBytes) {
+			chunks = append(chunks, s[start:i])
+			start, size = i, 0
+		}
+		size += rowSize
+	}
+	return append(chunks, s[start:])
+}
+
+// BatchResult is the outcome of InsertBatchResult.
+type BatchResult struct {
+	Inserted int64
+	// Skipped holds the indexes of the input rows insert ignore skipped.
+	Skipped []int
+}
+
+func insertChunks(tdx Tdx, h *queryHooks, chunks [][]interface{}, ignore bool, report bool) (*BatchResult, error) {
+	ret := &BatchResult{}
+	offset := 0
+	now := h.now()
+	for _, chunk := range chunks {
+		if err := prepareInsert(tdx, now, chunk); err != nil {
+			return ret, err
+		}
+		n, skipped, err := insertChunk(tdx, h, chunk, ignore, report)
+		ret.Inserted += n
+		if err != nil {
+			return ret, err
+		}
+		for _, i := range skipped {
+			ret.Skipped = append(ret.Skipped, offset+i)
+		}
+		if err := afterInsertRows(tdx, chunk, skipped); err != nil {
+			return ret, err
+		}
+		offset += len(chunk)
+	}
+	return ret, nil
+}
+
+// prepareInsert runs the BeforeInsert hooks of rows and sets their create
+// times and first versions, once however often the rows are sent.
+func prepareInsert(tdx Tdx, now time.Time, rows []interface{}) error {
+	for _, s := range rows {
+		if err := beforeInsert(tdx, s); err != nil {
+			return err
+		}
+		touchTimes(s, now, true)
+		initVersion(s)
+	}
+	return nil
+}
+
+// errRowsSkipped rolls back a multi-row insert ignore that skipped rows.
+var errRowsSkipped = errors.New("rows skipped")
+
+// needsSkippedRows tells whether an insert ignore of rows like s has to
+// know which rows it skipped: for the report, the auto increment ids of the
+// others or their AfterInsert hooks.
+func needsSkippedRows(s interface{}, report bool) bool {
+	return report || hasAfterHook(s, afterInserterType) || hasAutoIncrementPk(reflect.TypeOf(s).Elem())
+}
+
+// insertChunk inserts chunk, already prepared, and returns the number of
+// rows inserted and the indexes of the rows insert ignore skipped when they
+// are known. When they have to be, tdx must be a transaction.
+func insertChunk(tdx Tdx, h *queryHooks, chunk []interface{}, ignore bool, report bool) (int64, []int, error) {
+	var step int64 = 1
+	if len(chunk) > 1 && hasAutoIncrementPk(reflect.TypeOf(chunk[0]).Elem()) {
+		var err error
+		if step, err = h.autoIncrementIncrement(tdx); err != nil {
+			return 0, nil, err
+		}
+	}
+	if !ignore || !needsSkippedRows(chunk[0], report) {
+		n, err := insertBatch(tdx, chunk, ignore, step)
+		return n, nil, err
+	}
+	// MySQL does not tell which rows of a multi-row insert ignore were
+	// skipped: when some were, the statement is rolled back and the rows
+	// sent one per statement
+	if len(chunk) > 1 {
+		var n int64
+		err := inSavepoint(tdx, "orm_insert_batch", func() error {
+			var err error
+			if n, err = insertBatch(tdx, chunk, true, step); err == nil && n < int64(len(chunk)) {
+				return errRowsSkipped
+			}
+			return err
+		})
+		if err != errRowsSkipped {
+			return n, nil, err
+		}
+	}
+	var inserted int64
+	var skipped []int
+	for i := range chunk {
+		n, err := insertBatch(tdx, chunk[i:i+1], true, 1)
+		if err != nil {
+			return inserted, skipped, err
+		}
+		if n == 0 {
+			skipped = append(skipped, i)
+		}
+		inserted += n
+	}
+	return inserted, skipped, nil
+}
+
+// afterInsertRows runs the AfterInsert hooks of the rows of chunk but the
+// skipped ones.
+func afterInsertRows(tdx Tdx, chunk []interface{}, skipped []int) error {
+	for i, s := range chunk {
+		if len(skipped) > 0 && skipped[0] == i {
+			skipped = skipped[1:]
+			continue
+		}
+		if err := afterInsert(tdx, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inSavepoint runs f in a savepoint of the transaction tdx, rolled back
+// when f fails.
+func inSavepoint(tdx Tdx, name string, f func() error) error {
+	if _, err := tdx.Exec("savepoint " + name); err != nil {
+		return err
+	}
+	if err := f(); err != nil {
+		if _, rerr := tdx.Exec("rollback to savepoint " + name); rerr != nil {
+			return rerr
+		}
+		return err
+	}
+	_, err := tdx.Exec("release savepoint " + name)
+	return err
+}
+
+func hasAutoIncrementPk(t reflect.Type) bool {
+	pk, ok := getPkFieldByType(t)
+	return ok && pk.Tag.Get("ai") == "true"
+}
+
+// autoIncrementIncrement returns the distance between consecutive auto
+// increment ids, from BatchOptions or else asked once from the server.
+func (h *queryHooks) autoIncrementIncrement(tdx Tdx) (int64, error) {
+	if h.batch.AutoIncrementIncrement > 0 {
+		return h.batch.AutoIncrementIncrement, nil
+	}
+	if step := h.aiIncrement.Load(); step > 0 {
+		return step, nil
+	}
+	step, err := selectInt(tdx, "select @@auto_increment_increment")
+	if err != nil {
+		return 0, err
+	}
+	if step <= 0 {
+		step = 1
+	}
+	h.aiIncrement.Store(step)
+	return step, nil
+}
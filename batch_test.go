@@ -0,0 +1,192 @@
+package orm
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFindInBatches(t *testing.T) {
+	fdb := &fakeDB{onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+		var last int64
+		if strings.Contains(query, "user_id > ?") {
+			last = args[len(args)-1].(int64)
+		}
+		ret := &fakeRows{cols: []string{"user_id", "name"}}
+		for i := last + 1; i <= 7 && i <= last+3; i++ {
+			ret.rows = append(ret.rows, []driver.Value{i, []byte("u")})
+		}
+		return ret, nil
+	}}
+	o := newFakeORM(fdb)
+
+	var users []*TestCursorUser
+	var sizes []int
+	err := o.FindInBatches(&users, 3, "name = ?", []interface{}{"u"}, func(batch interface{}) error {
+		sizes = append(sizes, len(*batch.(*[]*TestCursorUser)))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != 3 || sizes[0] != 3 || sizes[2] != 1 {
+		t.Fatalf("unexpected batch sizes %v", sizes)
+	}
+	// every batch loads the books relation with a second query
+	if q := fdb.queries[2].query; q != "select * from test_cursor_user where (name = ?) and user_id > ? order by user_id limit 3" {
+		t.Fatalf("unexpected query %s", q)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = o.FindInBatches(&users, 3, "", nil, func(batch interface{}) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Fatalf("paging should stop at the first error, got %v after %d calls", err, calls)
+	}
+}
+
+func testBatchUsers(n int, name string) []interface{} {
+	ret := make([]interface{}, n)
+	for i := range ret {
+		ret[i] = &TestCursorUser{Name: name}
+	}
+	return ret
+}
+
+func TestSplitBatch(t *testing.T) {
+	if chunks := splitBatch(testBatchUsers(10, "a"), BatchOptions{MaxRows: 4}); len(chunks) != 3 || len(chunks[2]) != 2 {
+		t.Fatalf("unexpected chunks by rows %d", len(chunks))
+	}
+	// one placeholder per row
+	if chunks := splitBatch(testBatchUsers(MaxPlaceholders+1, "a"), BatchOptions{}); len(chunks) != 2 || len(chunks[1]) != 1 {
+		t.Fatalf("unexpected chunks by placeholders %d", len(chunks))
+	}
+	big := strings.Repeat("x", 1000)
+	if chunks := splitBatch(testBatchUsers(10, big), BatchOptions{MaxBytes: 3000}); len(chunks) != 5 {
+		t.Fatalf("unexpected chunks by bytes %d", len(chunks))
+	}
+	if chunks := splitBatch(nil, BatchOptions{}); chunks != nil {
+		t.Fatal("empty input should have no chunk")
+	}
+}
+
+func TestInsertBatchChunks(t *testing.T) {
+	var next int64 = 100
+	fdb := &fakeDB{
+		onExec: func(query string, args []driver.Value) (driver.Result, error) {
+			first := next
+			next += int64(len(args)) * 2
+			return fakeInsertResult{lastInsertId: first, rowsAffected: int64(len(args))}, nil
+		},
+		onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+			return &fakeRows{cols: []string{"@@auto_increment_increment"}, rows: [][]driver.Value{{int64(2)}}}, nil
+		},
+	}
+	o := newFakeORM(fdb)
+	o.SetBatchOptions(BatchOptions{MaxRows: 2, InTransaction: true})
+	users := testBatchUsers(5, "a")
+	if err := o.InsertBatch(users, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(fdb.execs) != 3 || fdb.commits != 1 || len(fdb.queries) != 1 {
+		t.Fatalf("expected 3 statements in one transaction, got %d statements and %d commits", len(fdb.execs), fdb.commits)
+	}
+	for i, u := range users {
+		if id := u.(*TestCursorUser).UserId; id != int64(100+2*i) {
+			t.Fatalf("user %d got id %d", i, id)
+		}
+	}
+}
+
+func TestInsertBatchRejectsMixedTypes(t *testing.T) {
+	fdb := &fakeDB{}
+	o := newFakeORM(fdb)
+	err := o.InsertBatch([]interface{}{&TestCursorUser{}, &TestCursorBook{}}, false)
+	if err == nil || !strings.Contains(err.Error(), "element 1") {
+		t.Fatalf("mixed batch should be rejected, got %v", err)
+	}
+	if err := o.InsertBatch([]interface{}{&TestCursorUser{}, (*TestCursorUser)(nil)}, false); err == nil {
+		t.Fatal("nil element should be rejected")
+	}
+	if len(fdb.execs) != 0 {
+		t.Fatal("nothing should be sent")
+	}
+}
+
+type TestUintPkUser struct {
+	Id   uint32 `pk:"true" ai:"true"`
+	Name string
+}
+
+func TestInsertBatchIgnore(t *testing.T) {
+	fdb := &fakeDB{onExec: func(query string, args []driver.Value) (driver.Result, error) {
+		if strings.Contains(query, "savepoint ") {
+			return driver.RowsAffected(0), nil
+		}
+		var skipped int64
+		for _, a := range args {
+			if a == "dup" {
+				skipped++
+			}
+		}
+		return fakeInsertResult{lastInsertId: 9, rowsAffected: int64(len(args)) - skipped}, nil
+	}}
+	o := newFakeORM(fdb)
+	o.SetBatchOptions(BatchOptions{AutoIncrementIncrement: 1})
+
+	users := []interface{}{&TestUintPkUser{Name: "a"}, &TestUintPkUser{Name: "b"}}
+	if err := o.InsertBatch(users, true); err != nil {
+		t.Fatal(err)
+	}
+	// nothing was skipped, the multi-row statement is kept
+	if len(fdb.writes()) != 1 || fdb.commits != 1 || users[0].(*TestUintPkUser).Id != 9 || users[1].(*TestUintPkUser).Id != 10 {
+		t.Fatalf("ids should be assigned from one statement, got %d statements", len(fdb.writes()))
+	}
+
+	fdb.execs = nil
+	users = []interface{}{&TestUintPkUser{Name: "a"}, &TestUintPkUser{Name: "dup"}, &TestUintPkUser{Name: "b"}}
+	if err := o.InsertBatch(users, true); err != nil {
+		t.Fatal(err)
+	}
+	// the statement is rolled back and the rows go one by one, the skipped one keeps id 0
+	if fdb.execs[2].query != "rollback to savepoint orm_insert_batch" || len(fdb.writes()) != 4 || fdb.commits != 2 {
+		t.Fatalf("the rows should be sent again one by one, got %v", fdb.execQueries())
+	}
+	if users[0].(*TestUintPkUser).Id != 9 || users[1].(*TestUintPkUser).Id != 0 || users[2].(*TestUintPkUser).Id != 9 {
+		t.Fatal("ids should be assigned to the inserted rows")
+	}
+
+	users = []interface{}{&TestUintPkUser{Name: "a"}, &TestUintPkUser{Name: "dup"}, &TestUintPkUser{Name: "b"}}
+	ret, err := o.InsertBatchResult(users, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.Inserted != 2 || len(ret.Skipped) != 1 || ret.Skipped[0] != 1 {
+		t.Fatalf("unexpected result %+v", ret)
+	}
+	if users[0].(*TestUintPkUser).Id != 9 || users[1].(*TestUintPkUser).Id != 0 {
+		t.Fatal("ids should be assigned to the inserted rows only")
+	}
+}
+
+type TestMappedRow struct {
+	RowId int64 `pk:"true"`
+}
+
+func TestFindInBatchesMappedTable(t *testing.T) {
+	SetMapTable("test_mapped_row", "mapped_rows")
+	t.Cleanup(func() { delete(maptables, "test_mapped_row") })
+	fdb := &fakeDB{}
+	o := newFakeORM(fdb)
+	var rows []*TestMappedRow
+	if err := o.FindInBatches(&rows, 10, "", nil, func(interface{}) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if q := fdb.queries[0].query; q != "select * from mapped_rows order by row_id limit 10" {
+		t.Fatalf("the mapped table should be paged, got %s", q)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"unicode"
+
+	orm "github.com/mmczoo/go-orm"
+)
+
+// table is a table read from the database.
+type table struct {
+	Name        string
+	Columns     []*orm.ColumnInfo
+	ForeignKeys []*orm.ForeignKey
+}
+
+// goType returns the Go type of a column and the package it needs.
+func goType(c *orm.ColumnInfo) (string, string) {
+	t := strings.ToLower(c.Type)
+	unsigned := strings.Contains(t, "unsigned")
+	base := t
+	if i := strings.IndexAny(base, "( "); i >= 0 {
+		base = base[:i]
+	}
+	var typ, nullTyp, pkg string
+	switch base {
+	case "tinyint":
+		if strings.HasPrefix(t, "tinyint(1)") {
+			typ, nullTyp = "bool", "sql.NullBool"
+		} else if unsigned {
+			typ, nullTyp = "uint8", "sql.NullInt64"
+		} else {
+			typ, nullTyp = "int8", "sql.NullInt64"
+		}
+	case "smallint", "year":
+		typ, nullTyp = "int16", "sql.NullInt64"
+		if unsigned {
+			typ = "uint16"
+		}
+	case "mediumint", "int", "integer":
+		typ, nullTyp = "int32", "sql.NullInt64"
+		if unsigned {
+			typ = "uint32"
+		}
+	case "bigint":
+		typ, nullTyp = "int64", "sql.NullInt64"
+		if unsigned {
+			// sql.NullInt64 would overflow
+			typ, nullTyp = "uint64", "*uint64"
+		}
+	case "float":
+		typ, nullTyp = "float32", "sql.NullFloat64"
+	case "double", "real":
+		typ, nullTyp = "float64", "sql.NullFloat64"
+	case "date", "datetime", "timestamp":
+		typ, nullTyp, pkg = "time.Time", "sql.NullTime", "time"
+	case "json":
+		// nil holds NULL
+		return "json.RawMessage", "encoding/json"
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob", "bit":
+		return "[]byte", ""
+	default:
+		// char, text, decimal to keep its precision, enum and set
+		typ, nullTyp = "string", "sql.NullString"
+	}
+	if !c.Nullable {
+		return typ, pkg
+	}
+	if strings.HasPrefix(nullTyp, "sql.") {
+		return nullTyp, "database/sql"
+	}
+	return nullTyp, pkg
+}
+
+// fieldName returns a Go identifier for col, following the ORM's naming.
+func fieldName(col string) string {
+	name := orm.FieldNameOf(col)
+	b := strings.Builder{}
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			b.WriteRune(r)
+		}
+	}
+	name = b.String()
+	if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+		name = "C" + name
+	}
+	r := []rune(name)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+func typeName(table string) string {
+	return fieldName(table)
+}
+
+type field struct {
+	name, typ, tag string
+}
+
+// generate writes the Go source of the models of tables. With relations,
+// foreign keys become belongs_to and has_many fields where the ORM can load
+// them: the referencing column has the name of the referenced primary key.
+func generate(pkg string, tables []*table, relations bool) ([]byte, error) {
+	byName := map[string]*table{}
+	for _, t := range tables {
+		byName[t.Name] = t
+	}
+	pkOf := func(t *table) string {
+		for _, c := range t.Columns {
+			if c.Key == "PRI" {
+				return c.Name
+			}
+		}
+		return ""
+	}
+	// hasMany lists, by referenced table, the tables pointing at it
+	hasMany := map[string][]string{}
+	if relations {
+		for _, t := range tables {
+			for _, fk := range t.ForeignKeys {
+				if ref, ok := byName[fk.RefTable]; ok && fk.Column == fk.RefColumn && pkOf(ref) == fk.RefColumn {
+					hasMany[fk.RefTable] = append(hasMany[fk.RefTable], t.Name)
+				}
+			}
+		}
+	}
+
+	imports := map[string]bool{}
+	body := &bytes.Buffer{}
+	for _, t := range tables {
+		fields := []field{}
+		used := map[string]bool{}
+		unique := func(name string) string {
+			ret := name
+			for i := 2; used[ret]; i++ {
+				ret = fmt.Sprintf("%s%d", name, i)
+			}
+			used[ret] = true
+			return ret
+		}
+		for _, c := range t.Columns {
+			typ, imp := goType(c)
+			if imp != "" {
+				imports[imp] = true
+			}
+			name := unique(fieldName(c.Name))
+			tags := []string{}
+			if c.Key == "PRI" {
+				tags = append(tags, `pk:"true"`)
+			}
+			if strings.Contains(strings.ToLower(c.Extra), "auto_increment") {
+				tags = append(tags, `ai:"true"`)
+			}
+			if orm.ColumnNameOf(name) != c.Name {
+				tags = append(tags, fmt.Sprintf("db:%q", c.Name))
+			}
+			fields = append(fields, field{name, typ, strings.Join(tags, " ")})
+		}
+		if relations {
+			for _, fk := range t.ForeignKeys {
+				if ref, ok := byName[fk.RefTable]; ok && fk.Column == fk.RefColumn && pkOf(ref) == fk.RefColumn {
+					fields = append(fields, field{unique(typeName(fk.RefTable)), "*" + typeName(fk.RefTable),
+						fmt.Sprintf(`or:"belongs_to" table:%q`, fk.RefTable)})
+				}
+			}
+			children := hasMany[t.Name]
+			sort.Strings(children)
+			for _, child := range children {
+				fields = append(fields, field{unique(typeName(child) + "s"), "[]*" + typeName(child),
+					fmt.Sprintf(`or:"has_many" table:%q`, child)})
+			}
+		}
+
+		fmt.Fprintf(body, "\n// %s is a row of %s.\ntype %s struct {\n", typeName(t.Name), t.Name, typeName(t.Name))
+		for _, f := range fields {
+			if f.tag != "" {
+				fmt.Fprintf(body, "\t%s %s `%s`\n", f.name, f.typ, f.tag)
+			} else {
+				fmt.Fprintf(body, "\t%s %s\n", f.name, f.typ)
+			}
+		}
+		body.WriteString("}\n")
+		if orm.ColumnNameOf(typeName(t.Name)) != t.Name {
+			fmt.Fprintf(body, "\nfunc init() {\n\torm.SetMapTable(%q, %q)\n}\n", orm.ColumnNameOf(typeName(t.Name)), t.Name)
+			imports["github.com/mmczoo/go-orm"] = true
+		}
+	}
+
+	out := &bytes.Buffer{}
+	out.WriteString("// Code generated by ormgen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(out, "package %s\n", pkg)
+	if len(imports) > 0 {
+		paths := make([]string, 0, len(imports))
+		for p := range imports {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		out.WriteString("\nimport (\n")
+		for _, p := range paths {
+			fmt.Fprintf(out, "\t%q\n", p)
+		}
+		out.WriteString(")\n")
+	}
+	out.Write(body.Bytes())
+	return format.Source(out.Bytes())
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	orm "github.com/mmczoo/go-orm"
+)
+
+func TestGenerate(t *testing.T) {
+	tables := []*table{
+		{Name: "user", Columns: []*orm.ColumnInfo{
+			{Name: "user_id", Type: "bigint(20) unsigned", Key: "PRI", Extra: "auto_increment"},
+			{Name: "nickName", Type: "varchar(64)", Nullable: true},
+			{Name: "active", Type: "tinyint(1)"},
+			{Name: "created_at", Type: "datetime", Nullable: true},
+		}},
+		{Name: "book", Columns: []*orm.ColumnInfo{
+			{Name: "book_id", Type: "int(11)", Key: "PRI", Extra: "auto_increment"},
+			{Name: "user_id", Type: "bigint(20) unsigned", Key: "MUL"},
+			{Name: "price", Type: "decimal(10,2)"},
+			{Name: "meta", Type: "json", Nullable: true},
+		}, ForeignKeys: []*orm.ForeignKey{{Column: "user_id", RefTable: "user", RefColumn: "user_id"}}},
+	}
+	src, err := generate("model", tables, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// gofmt aligns the fields, compare with single spaces
+	got := strings.Join(strings.Fields(string(src)), " ")
+	for _, want := range []string{
+		"package model",
+		`"database/sql"`,
+		`"encoding/json"`,
+		"UserId    uint64         `pk:\"true\" ai:\"true\"`",
+		"NickName  sql.NullString `db:\"nickName\"`",
+		"Active    bool",
+		"CreatedAt sql.NullTime",
+		"Price  string",
+		"Meta   json.RawMessage",
+		"User   *User           `or:\"belongs_to\" table:\"user\"`",
+		"Books     []*Book        `or:\"has_many\" table:\"book\"`",
+	} {
+		if !strings.Contains(got, strings.Join(strings.Fields(want), " ")) {
+			t.Fatalf("missing %q in\n%s", want, src)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Command ormgen writes the model structs of the tables of a MySQL database.
+//
+//	ormgen -dsn 'user:pass@tcp(localhost:3306)/shop' -pkg model -out model/models.go
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	orm "github.com/mmczoo/go-orm"
+)
+
+func main() {
+	dsn := flag.String("dsn", "", "MySQL data source name")
+	tables := flag.String("tables", "", "comma separated tables, all tables when empty")
+	pkg := flag.String("pkg", "model", "package of the generated file")
+	out := flag.String("out", "", "output file, stdout when empty")
+	relations := flag.Bool("relations", false, "add belongs_to and has_many fields from foreign keys")
+	flag.Parse()
+	if *dsn == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	o := orm.NewORM(*dsn)
+	defer o.Close()
+	names := strings.Split(*tables, ",")
+	if *tables == "" {
+		var err error
+		if names, err = o.Tables(); err != nil {
+			log.Fatalln("can not list tables:", err)
+		}
+	}
+	defs := make([]*table, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		cols, err := o.Columns(name)
+		if err != nil {
+			log.Fatalln("can not read columns of", name+":", err)
+		}
+		t := &table{Name: name, Columns: cols}
+		if *relations {
+			if t.ForeignKeys, err = o.ForeignKeys(name); err != nil {
+				log.Fatalln("can not read foreign keys of", name+":", err)
+			}
+		}
+		defs = append(defs, t)
+	}
+
+	src, err := generate(*pkg, defs, *relations)
+	if err != nil {
+		log.Fatalln("can not format the generated code:", err)
+	}
+	if *out == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -0,0 +1,64 @@
+// Command ormquery writes typed Go functions for the named queries of SQL
+// files, checking their placeholders against the model structs:
+//
+//	ormquery -models ./model -out model/queries.go queries/*.sql
+//
+// The file goes in the models package. A query is introduced by a name line:
+//
+//	-- name: GetUserByEmail :one
+//	select * from user where email = :email;
+//
+//	-- name: ListUsers :many User
+//	select * from user where user_id in (:ids);
+//
+// :one and :many return models, :exec returns the sql.Result and :execrows
+// the rows affected. A placeholder takes the type of the model field of the
+// same column; one in an IN list takes a slice of the type of the column
+// before in.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+)
+
+func main() {
+	modelsDir := flag.String("models", ".", "directory of the models package")
+	out := flag.String("out", "", "output file, stdout when empty")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	pkg, models, err := parseModels(*modelsDir)
+	if err != nil {
+		log.Fatalln("can not read the models:", err)
+	}
+	queries := []*query{}
+	for _, name := range flag.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		qs, err := parseQueries(f)
+		f.Close()
+		if err != nil {
+			log.Fatalln(name+":", err)
+		}
+		queries = append(queries, qs...)
+	}
+
+	src, err := generate(pkg, models, queries)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if *out == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		log.Fatalln(err)
+	}
+}
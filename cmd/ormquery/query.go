@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	orm "github.com/mmczoo/go-orm"
+)
+
+// model is a struct of the models package.
+type model struct {
+	Name   string
+	Table  string
+	Fields []*modelField
+}
+
+type modelField struct {
+	Name   string
+	Column string
+	Type   string
+	// Imports are the packages Type refers to.
+	Imports []string
+}
+
+// field returns the field holding the column or field name.
+func (m *model) field(name string) *modelField {
+	for _, f := range m.Fields {
+		if f.Column == name || f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// parseModels reads the structs of the Go package in dir.
+func parseModels(dir string) (string, map[string]*model, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	fset := token.NewFileSet()
+	var pkgName string
+	models := map[string]*model{}
+	mapped := map[string]string{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			return "", nil, err
+		}
+		if pkgName != "" && pkgName != file.Name.Name {
+			return "", nil, fmt.Errorf("more than one package in %s", dir)
+		}
+		pkgName = file.Name.Name
+		imports := map[string]string{}
+		for _, imp := range file.Imports {
+			path := strings.Trim(imp.Path.Value, "\"")
+			name := path[strings.LastIndexByte(path, '/')+1:]
+			if imp.Name != nil {
+				name = imp.Name.Name
+			}
+			imports[name] = path
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			// orm.SetMapTable("type_name", "table") as written by ormgen
+			if call, ok := n.(*ast.CallExpr); ok {
+				if sel, ok := call.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "SetMapTable" && len(call.Args) == 2 {
+					from, ok1 := call.Args[0].(*ast.BasicLit)
+					to, ok2 := call.Args[1].(*ast.BasicLit)
+					if ok1 && ok2 {
+						mapped[strings.Trim(from.Value, "\"`")] = strings.Trim(to.Value, "\"`")
+					}
+				}
+				return true
+			}
+			spec, ok := n.(*ast.TypeSpec)
+			if !ok {
+				return true
+			}
+			st, ok := spec.Type.(*ast.StructType)
+			if !ok {
+				return false
+			}
+			m := &model{Name: spec.Name.Name, Table: orm.ColumnNameOf(spec.Name.Name)}
+			for _, f := range st.Fields.List {
+				var tag reflect.StructTag
+				if f.Tag != nil {
+					tag = reflect.StructTag(strings.Trim(f.Tag.Value, "`"))
+				}
+				if tag.Get("or") != "" || tag.Get("ignore") == "true" {
+					continue
+				}
+				for _, id := range f.Names {
+					col := tag.Get("db")
+					if col == "" {
+						col = orm.ColumnNameOf(id.Name)
+					}
+					mf := &modelField{Name: id.Name, Column: col, Type: types.ExprString(f.Type)}
+					ast.Inspect(f.Type, func(n ast.Node) bool {
+						if sel, ok := n.(*ast.SelectorExpr); ok {
+							if x, ok := sel.X.(*ast.Ident); ok && imports[x.Name] != "" {
+								mf.Imports = append(mf.Imports, imports[x.Name])
+							}
+						}
+						return true
+					})
+					m.Fields = append(m.Fields, mf)
+				}
+			}
+			models[m.Name] = m
+			return false
+		})
+	}
+	for _, m := range models {
+		if table, ok := mapped[m.Table]; ok {
+			m.Table = table
+		}
+	}
+	return pkgName, models, nil
+}
+
+// query is a named query of a SQL file:
+//
+//	-- name: GetUserByEmail :one User
+//	select * from user where email = :email;
+//
+// The kind is :one, :many, :exec or :execrows. The model of :one and :many
+// defaults to the one of the table after from.
+type query struct {
+	Name  string
+	Kind  string
+	Model string
+	SQL   string
+}
+
+var (
+	nameLine = regexp.MustCompile(`^--\s*name:\s*(\w+)\s+:(one|many|exec|execrows)(?:\s+(\w+))?\s*$`)
+	fromReg  = regexp.MustCompile("(?i)\\b(?:from|into|update)\\s+`?(\\w+)`?")
+	inReg    = regexp.MustCompile("(?i)`?(\\w+)`?\\s+in\\s*\\(\\s*:(\\w+)\\s*\\)")
+)
+
+func parseQueries(r io.Reader) ([]*query, error) {
+	ret := []*query{}
+	var cur *query
+	var buf strings.Builder
+	end := func() {
+		if cur != nil {
+			cur.SQL = strings.TrimSuffix(strings.TrimSpace(buf.String()), ";")
+			ret = append(ret, cur)
+		}
+		buf.Reset()
+	}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if m := nameLine.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			end()
+			cur = &query{Name: m[1], Kind: m[2], Model: m[3]}
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		if cur == nil && strings.TrimSpace(line) != "" {
+			return nil, fmt.Errorf("line %d: SQL before the first -- name: line", n)
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	end()
+	return ret, sc.Err()
+}
+
+// param is a named placeholder of a query.
+type param struct {
+	Name    string
+	Type    string
+	Imports []string
+}
+
+// bindParams replaces the :name placeholders of q by ? and resolves their
+// types on the fields of m. It returns the rewritten SQL, the function
+// parameters and the argument of each placeholder.
+func bindParams(q *query, m *model) (string, []*param, []string, error) {
+	inCols := map[string]string{}
+	for _, match := range inReg.FindAllStringSubmatch(q.SQL, -1) {
+		inCols[match[2]] = match[1]
+	}
+	var buf strings.Builder
+	params := []*param{}
+	byName := map[string]*param{}
+	args := []string{}
+	s := q.SQL
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\'' || c == '"' || c == '`' {
+			j := i + 1
+			for j < len(s) && s[j] != c {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			buf.WriteString(s[i:min(j+1, len(s))])
+			i = j
+			continue
+		}
+		if c != ':' || i+1 >= len(s) || !isIdentStart(s[i+1]) || (i > 0 && s[i-1] == ':') {
+			buf.WriteByte(c)
+			continue
+		}
+		j := i + 1
+		for j < len(s) && (isIdentStart(s[j]) || s[j] >= '0' && s[j] <= '9') {
+			j++
+		}
+		name := s[i+1 : j]
+		p, ok := byName[name]
+		if !ok {
+			if m == nil {
+				return "", nil, nil, fmt.Errorf("%s: no model to check :%s against", q.Name, name)
+			}
+			p = &param{Name: argName(name)}
+			if col, isIn := inCols[name]; isIn {
+				f := m.field(col)
+				if f == nil {
+					return "", nil, nil, fmt.Errorf("%s: %s has no field for column %s of :%s", q.Name, m.Name, col, name)
+				}
+				p.Type, p.Imports = "[]"+f.Type, f.Imports
+			} else {
+				f := m.field(name)
+				if f == nil {
+					return "", nil, nil, fmt.Errorf("%s: %s has no field for :%s", q.Name, m.Name, name)
+				}
+				p.Type, p.Imports = f.Type, f.Imports
+			}
+			byName[name] = p
+			params = append(params, p)
+		}
+		buf.WriteByte('?')
+		args = append(args, p.Name)
+		i = j - 1
+	}
+	return buf.String(), params, args, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+// argName turns a placeholder into a Go parameter name, user_id into userId.
+func argName(name string) string {
+	f := orm.FieldNameOf(name)
+	ret := strings.ToLower(f[:1]) + f[1:]
+	if token.IsKeyword(ret) {
+		ret += "Arg"
+	}
+	return ret
+}
+
+// generate writes the Go functions of queries, checked against models.
+func generate(pkg string, models map[string]*model, queries []*query) ([]byte, error) {
+	byTable := map[string]*model{}
+	for _, m := range models {
+		byTable[m.Table] = m
+	}
+	body := &bytes.Buffer{}
+	imports := map[string]bool{"github.com/mmczoo/go-orm": true}
+	seen := map[string]bool{}
+	for _, q := range queries {
+		if seen[q.Name] {
+			return nil, errors.New("duplicate query " + q.Name)
+		}
+		seen[q.Name] = true
+		var m *model
+		if q.Model != "" {
+			if m = models[q.Model]; m == nil {
+				return nil, fmt.Errorf("%s: unknown model %s", q.Name, q.Model)
+			}
+		} else if match := fromReg.FindStringSubmatch(q.SQL); match != nil {
+			m = byTable[match[1]]
+		}
+		if m == nil && (q.Kind == "one" || q.Kind == "many") {
+			return nil, fmt.Errorf("%s: can not tell the model, name it after :%s", q.Name, q.Kind)
+		}
+		sqlText, params, args, err := bindParams(q, m)
+		if err != nil {
+			return nil, err
+		}
+		decl := []string{"db orm.ORMer"}
+		for _, p := range params {
+			decl = append(decl, p.Name+" "+p.Type)
+			for _, imp := range p.Imports {
+				imports[imp] = true
+			}
+		}
+		call := lowerFirst(q.Name) + "SQL"
+		if len(args) > 0 {
+			call += ", " + strings.Join(args, ", ")
+		}
+
+		fmt.Fprintf(body, "\nconst %sSQL = %q\n\n", lowerFirst(q.Name), sqlText)
+		fmt.Fprintf(body, "// %s runs the %s query.\n", q.Name, q.Name)
+		switch q.Kind {
+		case "one":
+			fmt.Fprintf(body, "func %s(%s) (*%s, error) {\n\tret := &%s{}\n\tif err := db.SelectOne(ret, %s); err != nil {\n\t\treturn nil, err\n\t}\n\treturn ret, nil\n}\n",
+				q.Name, strings.Join(decl, ", "), m.Name, m.Name, call)
+		case "many":
+			fmt.Fprintf(body, "func %s(%s) ([]*%s, error) {\n\tret := []*%s{}\n\terr := db.Select(&ret, %s)\n\treturn ret, err\n}\n",
+				q.Name, strings.Join(decl, ", "), m.Name, m.Name, call)
+		case "exec":
+			imports["database/sql"] = true
+			fmt.Fprintf(body, "func %s(%s) (sql.Result, error) {\n\treturn db.Exec(%s)\n}\n",
+				q.Name, strings.Join(decl, ", "), call)
+		case "execrows":
+			fmt.Fprintf(body, "func %s(%s) (int64, error) {\n\tret, err := db.Exec(%s)\n\tif err != nil {\n\t\treturn 0, err\n\t}\n\treturn ret.RowsAffected()\n}\n",
+				q.Name, strings.Join(decl, ", "), call)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	out.WriteString("// Code generated by ormquery. DO NOT EDIT.\n\n")
+	fmt.Fprintf(out, "package %s\n\nimport (\n", pkg)
+	paths := make([]string, 0, len(imports))
+	for p := range imports {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		fmt.Fprintf(out, "\t%q\n", p)
+	}
+	out.WriteString(")\n")
+	out.Write(body.Bytes())
+	return format.Source(out.Bytes())
+}
+
+func lowerFirst(s string) string {
+	return strings.ToLower(s[:1]) + s[1:]
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testModels = `package model
+
+import (
+	"database/sql"
+
+	orm "github.com/mmczoo/go-orm"
+)
+
+type User struct {
+	UserId   int64 ` + "`pk:\"true\" ai:\"true\"`" + `
+	Email    string
+	NickName sql.NullString ` + "`db:\"nickName\"`" + `
+	Books    []*Book ` + "`or:\"has_many\" table:\"book\"`" + `
+}
+
+type Book struct {
+	BookId int64
+	UserId int64
+}
+
+func init() {
+	orm.SetMapTable("book", "books")
+}
+`
+
+const testQueries = `-- name: GetUserByEmail :one
+select * from user where email = :email and email <> ':x';
+
+-- name: ListBooks :many
+-- the books of some users
+select * from books where user_id in (:user_ids) or book_id = :book_id;
+
+-- name: RenameUser :execrows
+update user set nickName = :nickName where user_id = :user_id;
+`
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "model.go"), []byte(testModels), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pkg, models, err := parseModels(dir)
+	if err != nil || pkg != "model" || len(models) != 2 || models["Book"].Table != "books" {
+		t.Fatalf("unexpected models %v %v %v", pkg, models, err)
+	}
+	queries, err := parseQueries(strings.NewReader(testQueries))
+	if err != nil || len(queries) != 3 {
+		t.Fatalf("unexpected queries %v %v", queries, err)
+	}
+	src, err := generate(pkg, models, queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`const getUserByEmailSQL = "select * from user where email = ? and email <> ':x'"`,
+		"func GetUserByEmail(db orm.ORMer, email string) (*User, error) {",
+		"func ListBooks(db orm.ORMer, userIds []int64, bookId int64) ([]*Book, error) {",
+		"err := db.Select(&ret, listBooksSQL, userIds, bookId)",
+		"func RenameUser(db orm.ORMer, nickName sql.NullString, userId int64) (int64, error) {",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Fatalf("missing %q in\n%s", want, src)
+		}
+	}
+
+	bad, _ := parseQueries(strings.NewReader("-- name: Bad :one\nselect * from user where mail = :mail"))
+	if _, err := generate(pkg, models, bad); err == nil || !strings.Contains(err.Error(), ":mail") {
+		t.Fatalf("unknown placeholder should be rejected, got %v", err)
+	}
+}
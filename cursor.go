@@ -0,0 +1,246 @@
+package orm
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"time"
+)
+
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// isModelType tells whether rows are scanned into t field by field, or
+// whether t is handed to database/sql as a single scan target.
+func isModelType(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != timeType && !reflect.PtrTo(t).Implements(scannerType)
+}
+
+// Cursor streams the rows of a query instead of loading them all, it must
+// be closed once done.
+type Cursor struct {
+	rows     *sql.Rows
+	cols     []string
+	plan     *scanPlan
+	planType reflect.Type
+	span     *opSpan
+	count    int64
+	closed   bool
+}
+
+func openCursor(tdx Tdx, span *opSpan, query string, args ...interface{}) (*Cursor, error) {
+	rows, err := tdx.Query(query, args...)
+	if err != nil {
+		span.end(-1, err)
+		return nil, err
+	}
+	cols, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		span.end(-1, err)
+		return nil, err
+	}
+	return &Cursor{rows: rows, cols: cols, span: span}, nil
+}
+
+// Next prepares the next row for Scan, it returns false at the end of the
+// result set or on error, see Err.
+func (c *Cursor) Next() bool {
+	if c.rows.Next() {
+		c.count++
+		return true
+	}
+	return false
+}
+
+func (c *Cursor) Columns() []string {
+	return c.cols
+}
+
+// Scan reads the current row into s, a pointer to a model struct or, for
+// single column results, to any value database/sql can scan into.
+func (c *Cursor) Scan(s interface{}) error {
+	v := reflect.ValueOf(s)
+	if v.Kind() != reflect.Ptr {
+		return errors.New("holder should be pointer")
+	}
+	t := v.Type().Elem()
+	if !isModelType(t) {
+		return c.rows.Scan(s)
+	}
+	if c.planType != t {
+		c.plan = newScanPlan(t, c.cols)
+		c.planType = t
+	}
+	return c.plan.scan(c.rows, v)
+}
+
+func (c *Cursor) Err() error {
+	return c.rows.Err()
+}
+
+func (c *Cursor) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	err := c.rows.Close()
+	c.span.end(c.count, c.rows.Err())
+	return err
+}
+
+// iterateFunc checks that fn is a func(*T) error and returns T.
+func iterateFunc(fn interface{}) (reflect.Value, reflect.Type, error) {
+	fv := reflect.ValueOf(fn)
+	ft := fv.Type()
+	if ft.Kind() != reflect.Func || ft.NumIn() != 1 || ft.In(0).Kind() != reflect.Ptr ||
+		ft.NumOut() != 1 || ft.Out(0) != errorType {
+		return fv, nil, errors.New("iterate callback should be func(*T) error, got " + ft.String())
+	}
+	return fv, ft.In(0).Elem(), nil
+}
+
+func callIterateFunc(fv reflect.Value, v reflect.Value) error {
+	out := fv.Call([]reflect.Value{v})
+	if out[0].IsNil() {
+		return nil
+	}
+	return out[0].Interface().(error)
+}
+
+// iterate scans each row of c into a new T and calls fn with it, stopping at
+// the first error fn returns. It closes c.
+func iterate(c *Cursor, fv reflect.Value, t reflect.Type) (err error) {
+	defer func() {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	for c.Next() {
+		v := reflect.New(t)
+		if err := c.Scan(v.Interface()); err != nil {
+			return err
+		}
+		if err := callIterateFunc(fv, v); err != nil {
+			return err
+		}
+	}
+	return c.Err()
+}
+
+// iterateChunked works like iterate, but loads the or relations of every
+// chunk rows before handing them to fn.
+func iterateChunked(tdx Tdx, c *Cursor, chunk int, fv reflect.Value, t reflect.Type) (err error) {
+	defer func() {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	if !isModelType(t) {
+		return errors.New("can not preload relations of " + t.String())
+	}
+	if chunk <= 0 {
+		return errors.New("chunk size should be positive")
+	}
+	pkCol, orCols := getOrColumnsByType(t)
+	buf := make([]reflect.Value, 0, chunk)
+	flush := func() error {
+		if len(orCols) > 0 {
+			keys := make([]interface{}, 0, len(buf))
+			resMap := make(map[interface{}]reflect.Value, len(buf))
+			for _, v := range buf {
+				pkFv := v.Elem().FieldByName(pkCol.Name)
+				if pkFv.IsValid() {
+					key := pkFv.Interface()
+					keys = append(keys, key)
+					resMap[key] = v
+				}
+			}
+			if len(keys) > 0 {
+				if err := loadManyRelations(tdx, pkCol, orCols, keys, resMap); err != nil {
+					return err
+				}
+			}
+		}
+		for _, v := range buf {
+			if err := callIterateFunc(fv, v); err != nil {
+				return err
+			}
+		}
+		buf = buf[:0]
+		return nil
+	}
+	for c.Next() {
+		v := reflect.New(t)
+		if err := c.Scan(v.Interface()); err != nil {
+			return err
+		}
+		buf = append(buf, v)
+		if len(buf) == chunk {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := c.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// Cursor runs query and returns a Cursor over its rows.
+func (o *ORM) Cursor(query string, args ...interface{}) (*Cursor, error) {
+	tdx, span := o.startOp("Cursor", "", query)
+	return openCursor(tdx, span, query, args...)
+}
+
+// Iterate calls fn, a func(*T) error, with every row of query scanned into a
+// new T without loading the whole result. A non-nil error from fn stops the
+// iteration and is returned.
+func (o *ORM) Iterate(fn interface{}, query string, args ...interface{}) error {
+	fv, t, err := iterateFunc(fn)
+	if err != nil {
+		return err
+	}
+	c, err := o.Cursor(query, args...)
+	if err != nil {
+		return err
+	}
+	return iterate(c, fv, t)
+}
+
+// IteratePreload works like Iterate, but loads the or relations of every
+// chunk rows first. The relation queries need a second connection, so it is
+// not offered on ORMTran.
+func (o *ORM) IteratePreload(chunk int, fn interface{}, query string, args ...interface{}) error {
+	fv, t, err := iterateFunc(fn)
+	if err != nil {
+		return err
+	}
+	tdx, span := o.startOp("IteratePreload", getTableName(reflect.New(t).Interface()), query)
+	c, err := openCursor(tdx, span, query, args...)
+	if err != nil {
+		return err
+	}
+	return iterateChunked(tdx, c, chunk, fv, t)
+}
+
+func (o *ORMTran) Cursor(query string, args ...interface{}) (*Cursor, error) {
+	tdx, span := o.startOp("Cursor", "", query)
+	return openCursor(tdx, span, query, args...)
+}
+
+func (o *ORMTran) Iterate(fn interface{}, query string, args ...interface{}) error {
+	fv, t, err := iterateFunc(fn)
+	if err != nil {
+		return err
+	}
+	c, err := o.Cursor(query, args...)
+	if err != nil {
+		return err
+	}
+	return iterate(c, fv, t)
+}
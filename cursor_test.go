@@ -0,0 +1,111 @@
+package orm
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type TestCursorUser struct {
+	UserId int64 `pk:"true" ai:"true"`
+	Name   string
+	Books  []*TestCursorBook `or:"has_many" table:"test_cursor_book"`
+}
+
+type TestCursorBook struct {
+	BookId int64 `pk:"true" ai:"true"`
+	UserId int64
+	Title  string
+}
+
+func cursorUsers(n int) *fakeRows {
+	ret := &fakeRows{cols: []string{"user_id", "name", "unknown_col"}}
+	for i := 1; i <= n; i++ {
+		ret.rows = append(ret.rows, []driver.Value{int64(i), []byte("user"), int64(0)})
+	}
+	return ret
+}
+
+func TestIterate(t *testing.T) {
+	o := newFakeORM(&fakeDB{onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+		return cursorUsers(5), nil
+	}})
+	var ids []int64
+	err := o.Iterate(func(u *TestCursorUser) error {
+		ids = append(ids, u.UserId)
+		return nil
+	}, "select * from test_cursor_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 5 || ids[4] != 5 {
+		t.Fatalf("unexpected ids %v", ids)
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	err = o.Iterate(func(u *TestCursorUser) error {
+		n++
+		if n == 2 {
+			return stop
+		}
+		return nil
+	}, "select * from test_cursor_user")
+	if err != stop || n != 2 {
+		t.Fatalf("iteration should stop early, got %v after %d rows", err, n)
+	}
+
+	if err := o.Iterate(func(u TestCursorUser) {}, "select 1"); err == nil {
+		t.Fatal("invalid callback should be rejected")
+	}
+}
+
+func TestCursorScan(t *testing.T) {
+	o := newFakeORM(&fakeDB{onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+		return cursorUsers(2), nil
+	}})
+	c, err := o.Cursor("select * from test_cursor_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var users []TestCursorUser
+	for c.Next() {
+		var u TestCursorUser
+		if err := c.Scan(&u); err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, u)
+	}
+	if c.Err() != nil || len(users) != 2 || users[1].Name != "user" {
+		t.Fatalf("unexpected result %v %v", users, c.Err())
+	}
+}
+
+func TestIteratePreload(t *testing.T) {
+	fdb := &fakeDB{onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+		if strings.Contains(query, "test_cursor_book") {
+			return &fakeRows{
+				cols: []string{"book_id", "user_id", "title"},
+				rows: [][]driver.Value{{int64(1), int64(1), []byte("a")}, {int64(2), int64(3), []byte("b")}},
+			}, nil
+		}
+		return cursorUsers(3), nil
+	}}
+	o := newFakeORM(fdb)
+	var users []*TestCursorUser
+	err := o.IteratePreload(2, func(u *TestCursorUser) error {
+		users = append(users, u)
+		return nil
+	}, "select * from test_cursor_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 3 || len(users[0].Books) != 1 || len(users[1].Books) != 0 || len(users[2].Books) != 1 {
+		t.Fatalf("relations not loaded: %v", users)
+	}
+	if len(fdb.queries) != 3 {
+		t.Fatalf("expected one relation query per chunk, got %d queries", len(fdb.queries))
+	}
+}
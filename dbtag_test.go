@@ -0,0 +1,94 @@
+package orm
+
+import (
+	"database/sql/driver"
+	"testing"
+)
+
+type TestDbTagUser struct {
+	UserId   int64  `pk:"true" ai:"true"`
+	NickName string `db:"nickName"`
+}
+
+func TestDbTag(t *testing.T) {
+	fdb := &fakeDB{onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+		return &fakeRows{cols: []string{"user_id", "nickName", "nick_name"}, rows: [][]driver.Value{{int64(1), "a", "b"}}}, nil
+	}, onExec: func(query string, args []driver.Value) (driver.Result, error) {
+		return fakeInsertResult{lastInsertId: 2, rowsAffected: int64(len(args))}, nil
+	}}
+	o := newFakeORM(fdb)
+	o.SetBatchOptions(BatchOptions{AutoIncrementIncrement: 1})
+
+	u := &TestDbTagUser{}
+	if err := o.SelectOne(u, "select * from test_db_tag_user"); err != nil || u.NickName != "a" {
+		t.Fatalf("db tag should map the column, got %v %v", u, err)
+	}
+	var users []*TestDbTagUser
+	if err := o.Select(&users, "select * from test_db_tag_user"); err != nil || len(users) != 1 || users[0].NickName != "a" {
+		t.Fatalf("db tag should map the column of every row, got %v %v", users, err)
+	}
+
+	if err := o.Insert(&TestDbTagUser{NickName: "c"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if q := fdb.execs[0].query; q != "insert into test_db_tag_user (nickName) values(?)" {
+		t.Fatalf("unexpected insert %s", q)
+	}
+	if err := o.InsertBatch([]interface{}{&TestDbTagUser{NickName: "d"}, &TestDbTagUser{NickName: "e"}}, false); err != nil {
+		t.Fatal(err)
+	}
+	if q := fdb.execs[1].query; q != "insert into test_db_tag_user (nickName) values (?),(?)" {
+		t.Fatalf("unexpected batch insert %s", q)
+	}
+	u.NickName = "f"
+	if err := o.Update(u); err != nil {
+		t.Fatal(err)
+	}
+	if q := fdb.execs[2].query; q != "update test_db_tag_user set nickName = ? where user_id = ?" {
+		t.Fatalf("unexpected update %s", q)
+	}
+}
+
+type TestMappedUser struct {
+	UserId int64 `pk:"true" ai:"true"`
+	Name   string
+}
+
+func TestMappedTable(t *testing.T) {
+	SetMapTable("test_mapped_user", "users")
+	t.Cleanup(func() { delete(maptables, "test_mapped_user") })
+	fdb := &fakeDB{onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+		return &fakeRows{cols: []string{"user_id", "name"}, rows: [][]driver.Value{{int64(1), "a"}}}, nil
+	}, onExec: func(query string, args []driver.Value) (driver.Result, error) {
+		return fakeInsertResult{lastInsertId: 1, rowsAffected: 1}, nil
+	}}
+	o := newFakeORM(fdb)
+	o.AddTable(TestMappedUser{})
+
+	u := &TestMappedUser{Name: "a"}
+	if err := o.Insert(u, false); err != nil {
+		t.Fatal(err)
+	}
+	if q := fdb.execs[0].query; q != "insert into users (name) values(?)" {
+		t.Fatalf("unexpected insert %s", q)
+	}
+	if err := o.SelectByPK(u, 1); err != nil {
+		t.Fatal(err)
+	}
+	if q := fdb.queries[0].query; q != "select * from users where user_id = ?" {
+		t.Fatalf("unexpected select %s", q)
+	}
+	u.Name = "b"
+	if err := o.Update(u); err != nil {
+		t.Fatal(err)
+	}
+	if q := fdb.execs[1].query; q != "update users set name = ? where user_id = ?" {
+		t.Fatalf("unexpected update %s", q)
+	}
+	if err := o.TruncateTables(); err != nil {
+		t.Fatal(err)
+	}
+	if q := fdb.execs[2].query; q != "truncate table users" {
+		t.Fatalf("unexpected truncate %s", q)
+	}
+}
@@ -0,0 +1,247 @@
+package orm
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dialect writes the DDL statements of a database from table definitions.
+type Dialect interface {
+	Name() string
+	// ColumnType returns the SQL type of c, without nullability.
+	ColumnType(c *ColumnDef) (string, error)
+	CreateTable(t *TableDef) ([]string, error)
+	AddColumn(table string, c *ColumnDef) (string, error)
+	AddIndex(table string, idx *IndexDef) string
+	// TransactionalDDL tells whether DDL can be rolled back, which decides
+	// whether migrations run in a transaction.
+	TransactionalDDL() bool
+	// AcquireLock takes the named lock for the session of conn, waiting at
+	// most timeout. ReleaseLock frees it.
+	AcquireLock(ctx context.Context, conn *sql.Conn, name string, timeout time.Duration) error
+	ReleaseLock(ctx context.Context, conn *sql.Conn, name string) error
+}
+
+// ErrLockTimeout is returned when a lock is held by someone else for too long.
+var ErrLockTimeout = errors.New("lock timeout")
+
+// MySQLDialect is the default Dialect.
+type MySQLDialect struct {
+	// Engine and Charset are added to CREATE TABLE when set.
+	Engine  string
+	Charset string
+}
+
+// SetDialect changes the Dialect of CreateTables, AutoMigrate and Migrator.
+func (o *ORM) SetDialect(d Dialect) {
+	o.hooks.dialect = d
+}
+
+func (h *queryHooks) getDialect() Dialect {
+	if h == nil || h.dialect == nil {
+		return MySQLDialect{}
+	}
+	return h.dialect
+}
+
+var (
+	rawMessageType  = reflect.TypeOf(json.RawMessage{})
+	nullStringType  = reflect.TypeOf(sql.NullString{})
+	nullInt64Type   = reflect.TypeOf(sql.NullInt64{})
+	nullInt32Type   = reflect.TypeOf(sql.NullInt32{})
+	nullInt16Type   = reflect.TypeOf(sql.NullInt16{})
+	nullByteType    = reflect.TypeOf(sql.NullByte{})
+	nullFloat64Type = reflect.TypeOf(sql.NullFloat64{})
+	nullBoolType    = reflect.TypeOf(sql.NullBool{})
+)
+
+func (MySQLDialect) Name() string { return "mysql" }
+
+func (d MySQLDialect) quote(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func (d MySQLDialect) ColumnType(c *ColumnDef) (string, error) {
+	if c.SQLType != "" {
+		return c.SQLType, nil
+	}
+	t := c.GoType
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType, nullTimeType, softDeleteType:
+		if c.Size > 0 {
+			return "DATETIME(" + strconv.Itoa(c.Size) + ")", nil
+		}
+		return "DATETIME", nil
+	case rawMessageType:
+		return "JSON", nil
+	case nullStringType:
+		return d.stringType(c.Size), nil
+	case nullInt64Type:
+		return "BIGINT", nil
+	case nullInt32Type:
+		return "INT", nil
+	case nullInt16Type:
+		return "SMALLINT", nil
+	case nullByteType:
+		return "TINYINT UNSIGNED", nil
+	case nullFloat64Type:
+		return "DOUBLE", nil
+	case nullBoolType:
+		return "TINYINT(1)", nil
+	}
+	ret := ""
+	switch t.Kind() {
+	case reflect.Bool:
+		return "TINYINT(1)", nil
+	case reflect.Int8, reflect.Uint8:
+		ret = "TINYINT"
+	case reflect.Int16, reflect.Uint16:
+		ret = "SMALLINT"
+	case reflect.Int32, reflect.Uint32:
+		ret = "INT"
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		ret = "BIGINT"
+	case reflect.Float32:
+		return "FLOAT", nil
+	case reflect.Float64:
+		return "DOUBLE", nil
+	case reflect.String:
+		return d.stringType(c.Size), nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			switch {
+			case c.Size == 0 || c.Size > 16777215:
+				return "LONGBLOB", nil
+			case c.Size > 65535:
+				return "MEDIUMBLOB", nil
+			}
+			return "VARBINARY(" + strconv.Itoa(c.Size) + ")", nil
+		}
+	}
+	if ret == "" {
+		return "", errors.New("no SQL type for " + t.String() + " of column " + c.Name + ", set the type tag")
+	}
+	if t.Kind() >= reflect.Uint && t.Kind() <= reflect.Uint64 {
+		ret += " UNSIGNED"
+	}
+	return ret, nil
+}
+
+func (MySQLDialect) stringType(size int) string {
+	switch {
+	case size == 0:
+		return "VARCHAR(255)"
+	case size <= 16383:
+		return "VARCHAR(" + strconv.Itoa(size) + ")"
+	case size <= 65535:
+		return "TEXT"
+	case size <= 16777215:
+		return "MEDIUMTEXT"
+	}
+	return "LONGTEXT"
+}
+
+func (d MySQLDialect) column(c *ColumnDef) (string, error) {
+	tp, err := d.ColumnType(c)
+	if err != nil {
+		return "", err
+	}
+	ret := d.quote(c.Name) + " " + tp
+	if c.Nullable {
+		ret += " NULL"
+	} else {
+		ret += " NOT NULL"
+	}
+	if c.Default != nil {
+		ret += " DEFAULT " + *c.Default
+	}
+	if c.AutoIncrement {
+		ret += " AUTO_INCREMENT"
+	}
+	return ret, nil
+}
+
+func (d MySQLDialect) indexColumns(cols []string) string {
+	quoted := make([]string, len(cols))
+	for k, c := range cols {
+		quoted[k] = d.quote(c)
+	}
+	return "(" + strings.Join(quoted, ",") + ")"
+}
+
+func (d MySQLDialect) CreateTable(t *TableDef) ([]string, error) {
+	lines := make([]string, 0, len(t.Columns)+len(t.Indexes)+1)
+	pks := []string{}
+	for _, c := range t.Columns {
+		line, err := d.column(c)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+		if c.PrimaryKey {
+			pks = append(pks, c.Name)
+		}
+	}
+	if len(pks) > 0 {
+		lines = append(lines, "PRIMARY KEY "+d.indexColumns(pks))
+	}
+	for _, idx := range t.Indexes {
+		kind := "KEY "
+		if idx.Unique {
+			kind = "UNIQUE KEY "
+		}
+		lines = append(lines, kind+d.quote(idx.Name)+" "+d.indexColumns(idx.Columns))
+	}
+	q := "CREATE TABLE IF NOT EXISTS " + d.quote(t.Name) + " (\n  " + strings.Join(lines, ",\n  ") + "\n)"
+	if d.Engine != "" {
+		q += " ENGINE=" + d.Engine
+	}
+	if d.Charset != "" {
+		q += " DEFAULT CHARSET=" + d.Charset
+	}
+	return []string{q}, nil
+}
+
+func (d MySQLDialect) AddColumn(table string, c *ColumnDef) (string, error) {
+	col, err := d.column(c)
+	if err != nil {
+		return "", err
+	}
+	return "ALTER TABLE " + d.quote(table) + " ADD COLUMN " + col, nil
+}
+
+func (d MySQLDialect) AddIndex(table string, idx *IndexDef) string {
+	kind := "INDEX "
+	if idx.Unique {
+		kind = "UNIQUE INDEX "
+	}
+	return "CREATE " + kind + d.quote(idx.Name) + " ON " + d.quote(table) + " " + d.indexColumns(idx.Columns)
+}
+
+// TransactionalDDL is false, MySQL commits implicitly before DDL.
+func (MySQLDialect) TransactionalDDL() bool { return false }
+
+func (MySQLDialect) AcquireLock(ctx context.Context, conn *sql.Conn, name string, timeout time.Duration) error {
+	var ok sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "select get_lock(?, ?)", name, int64(timeout/time.Second)).Scan(&ok); err != nil {
+		return err
+	}
+	if !ok.Valid || ok.Int64 != 1 {
+		return ErrLockTimeout
+	}
+	return nil
+}
+
+func (MySQLDialect) ReleaseLock(ctx context.Context, conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(ctx, "select release_lock(?)", name)
+	return err
+}
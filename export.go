@@ -0,0 +1,177 @@
+package orm
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// ExportFormat selects the output of ExportQuery.
+type ExportFormat string
+
+const (
+	// ExportCSV writes RFC 4180 CSV with a header row, NULL is an empty field.
+	ExportCSV ExportFormat = "csv"
+	// ExportJSONL writes one JSON object per row with typed values, NULL is null.
+	ExportJSONL ExportFormat = "jsonl"
+	// ExportTSV writes tab separated values Excel opens directly: a UTF-8
+	// byte order mark, CRLF line ends and quoted fields where needed.
+	ExportTSV ExportFormat = "tsv"
+)
+
+// rowWriter writes the rows of an export.
+type rowWriter interface {
+	header(cols []string) error
+	row(values []interface{}) error
+	flush() error
+}
+
+type textRowWriter struct {
+	w      *csv.Writer
+	format *RawFormat
+	text   []string
+	// bom is the byte order mark written before the header
+	bom string
+	out io.Writer
+}
+
+func (t *textRowWriter) header(cols []string) error {
+	if t.bom != "" {
+		if _, err := io.WriteString(t.out, t.bom); err != nil {
+			return err
+		}
+	}
+	return t.w.Write(cols)
+}
+
+func (t *textRowWriter) row(values []interface{}) error {
+	if t.text == nil {
+		t.text = make([]string, len(values))
+	}
+	for k, v := range values {
+		t.text[k], _ = t.format.format(v)
+	}
+	return t.w.Write(t.text)
+}
+
+func (t *textRowWriter) flush() error {
+	t.w.Flush()
+	return t.w.Error()
+}
+
+type jsonRowWriter struct {
+	w    *bufio.Writer
+	keys [][]byte
+}
+
+func (j *jsonRowWriter) header(cols []string) error {
+	j.keys = make([][]byte, len(cols))
+	for k, c := range cols {
+		b, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		j.keys[k] = b
+	}
+	return nil
+}
+
+// row keeps the column order, which a map would lose.
+func (j *jsonRowWriter) row(values []interface{}) error {
+	j.w.WriteByte('{')
+	for k, v := range values {
+		if k > 0 {
+			j.w.WriteByte(',')
+		}
+		j.w.Write(j.keys[k])
+		j.w.WriteByte(':')
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		j.w.Write(b)
+	}
+	_, err := j.w.WriteString("}\n")
+	return err
+}
+
+func (j *jsonRowWriter) flush() error {
+	return j.w.Flush()
+}
+
+func newRowWriter(w io.Writer, format ExportFormat, raw *RawFormat) (rowWriter, error) {
+	// exports must not lose precision, whatever SelectRaw rounds to
+	f := DefaultRawFormat
+	if raw != nil {
+		f = *raw
+	}
+	f.FloatFormat, f.FloatPrecision = 'g', -1
+	f.Null = ""
+
+	switch format {
+	case ExportCSV:
+		return &textRowWriter{w: csv.NewWriter(w), format: &f}, nil
+	case ExportTSV:
+		cw := csv.NewWriter(w)
+		cw.Comma = '\t'
+		cw.UseCRLF = true
+		return &textRowWriter{w: cw, format: &f, bom: "\ufeff", out: w}, nil
+	case ExportJSONL:
+		return &jsonRowWriter{w: bufio.NewWriter(w)}, nil
+	}
+	return nil, errors.New("unsupported export format: " + string(format))
+}
+
+// exportQuery streams the rows of query to w and returns how many it wrote.
+// Nothing is written when the query fails.
+func exportQuery(tdx Tdx, raw *RawFormat, w io.Writer, format ExportFormat, query string, args ...interface{}) (int64, error) {
+	rw, err := newRowWriter(w, format, raw)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := tdx.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if err := rw.header(cols); err != nil {
+		return 0, err
+	}
+	var n int64
+	err = rawRows(rows, func(cols []string, values []interface{}) error {
+		if err := rw.row(values); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if ferr := rw.flush(); err == nil {
+		err = ferr
+	}
+	return n, err
+}
+
+// ExportQuery streams the result of query to w as CSV, JSON Lines or TSV
+// without loading it, and returns the number of rows written. Nothing is
+// written when the query fails; a row that can not be read stops the export
+// after the rows before it.
+func (o *ORM) ExportQuery(w io.Writer, format ExportFormat, query string, args ...interface{}) (int64, error) {
+	tdx, span := o.startOp("ExportQuery", "", query)
+	n, err := exportQuery(tdx, o.hooks.rawFormat, w, format, query, args...)
+	span.end(n, err)
+	return n, err
+}
+
+func (o *ORMTran) ExportQuery(w io.Writer, format ExportFormat, query string, args ...interface{}) (int64, error) {
+	tdx, span := o.startOp("ExportQuery", "", query)
+	n, err := exportQuery(tdx, o.hooks.rawFormat, w, format, query, args...)
+	span.end(n, err)
+	return n, err
+}
@@ -0,0 +1,81 @@
+package orm
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func exportTestRows(string, []driver.Value) (*fakeRows, error) {
+	return &fakeRows{
+		cols:  []string{"id", "name", "ratio", "doc", "note"},
+		types: []string{"BIGINT", "VARCHAR", "DOUBLE", "JSON", "VARCHAR"},
+		rows: [][]driver.Value{
+			{[]byte("1"), []byte("a,\"b\""), []byte("0.125"), []byte(`{"x":[1]}`), nil},
+			{[]byte("2"), []byte("tab\there"), []byte("2"), []byte(`null`), []byte("n")},
+		},
+	}, nil
+}
+
+func TestExportQuery(t *testing.T) {
+	o := newFakeORM(&fakeDB{onQuery: exportTestRows})
+
+	var buf bytes.Buffer
+	n, err := o.ExportQuery(&buf, ExportCSV, "select * from t")
+	if err != nil || n != 2 {
+		t.Fatalf("unexpected export %d %v", n, err)
+	}
+	want := "id,name,ratio,doc,note\n1,\"a,\"\"b\"\"\",0.125,\"{\"\"x\"\":[1]}\",\n2,tab\there,2,null,n\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected csv:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if _, err := o.ExportQuery(&buf, ExportJSONL, "select * from t"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || lines[0] != `{"id":1,"name":"a,\"b\"","ratio":0.125,"doc":{"x":[1]},"note":null}` {
+		t.Fatalf("unexpected jsonl:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if _, err := o.ExportQuery(&buf, ExportTSV, "select * from t"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "\ufeffid\tname") || !strings.Contains(buf.String(), "\"tab\there\"") ||
+		!strings.Contains(buf.String(), "\r\n") {
+		t.Fatalf("unexpected tsv:\n%q", buf.String())
+	}
+
+	if _, err := o.ExportQuery(&buf, "xml", "select * from t"); err == nil {
+		t.Fatal("unknown format should be rejected")
+	}
+}
+
+func TestExportQueryFailure(t *testing.T) {
+	o := newFakeORM(&fakeDB{onQuery: func(string, []driver.Value) (*fakeRows, error) {
+		return nil, errors.New("no such table")
+	}})
+	var buf bytes.Buffer
+	if n, err := o.ExportQuery(&buf, ExportTSV, "select * from missing"); err == nil || n != 0 || buf.Len() != 0 {
+		t.Fatalf("a failed query should write nothing, got %d %v %q", n, err, buf.String())
+	}
+
+	o = newFakeORM(&fakeDB{onQuery: func(string, []driver.Value) (*fakeRows, error) {
+		return &fakeRows{
+			cols:  []string{"id", "born"},
+			types: []string{"BIGINT", "DATE"},
+			rows:  [][]driver.Value{{[]byte("1"), []byte("1990-05-06")}, {[]byte("2"), []byte("1990-13-01")}},
+		}, nil
+	}})
+	n, err := o.ExportQuery(&buf, ExportCSV, "select * from t")
+	if err == nil || !strings.Contains(err.Error(), "column born") || n != 1 {
+		t.Fatalf("the bad row should stop the export, got %d %v", n, err)
+	}
+	if buf.String() != "id,born\n1,1990-05-06 00:00:00\n" {
+		t.Fatalf("the rows before should be written, got %q", buf.String())
+	}
+}
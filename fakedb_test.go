@@ -0,0 +1,187 @@
+package orm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+// fakeStmt is a statement received by a fakeDB.
+type fakeStmt struct {
+	query string
+	args  []driver.Value
+}
+
+// fakeRows is the canned result of a query.
+type fakeRows struct {
+	cols []string
+	// types are the optional database type names of cols
+	types []string
+	rows  [][]driver.Value
+}
+
+// fakeDB is a database/sql driver answering queries from handlers, so that
+// the ORM can be exercised without a MySQL server.
+type fakeDB struct {
+	mu      sync.Mutex
+	execs   []fakeStmt
+	queries []fakeStmt
+	// onQuery answers queries, nil returns an empty result.
+	onQuery func(query string, args []driver.Value) (*fakeRows, error)
+	// onExec answers statements, nil returns a result affecting one row.
+	onExec  func(query string, args []driver.Value) (driver.Result, error)
+	commits int
+	rolls   int
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("use fakeDB as connector")
+}
+
+// open returns a database on f, for the tests of the statement hooks.
+func (f *fakeDB) open() *sql.DB {
+	return sql.OpenDB(f)
+}
+
+func newFakeORM(f *fakeDB) *ORM {
+	return &ORM{
+		db:     f.open(),
+		tables: make(map[string]interface{}),
+		hooks:  &queryHooks{},
+	}
+}
+
+func (f *fakeDB) execQueries() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ret := make([]string, len(f.execs))
+	for i, e := range f.execs {
+		ret[i] = e.query
+	}
+	return ret
+}
+
+// writes returns the statements f received but the savepoint ones.
+func (f *fakeDB) writes() []fakeStmt {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ret := make([]fakeStmt, 0, len(f.execs))
+	for _, e := range f.execs {
+		if !strings.Contains(e.query, "savepoint ") {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeConnStmt{db: c.db, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) { return &fakeTx{db: c.db}, nil }
+
+type fakeTx struct {
+	db *fakeDB
+}
+
+func (t *fakeTx) Commit() error {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+	t.db.commits++
+	return nil
+}
+
+func (t *fakeTx) Rollback() error {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+	t.db.rolls++
+	return nil
+}
+
+type fakeConnStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeConnStmt) Close() error  { return nil }
+func (s *fakeConnStmt) NumInput() int { return -1 }
+
+func (s *fakeConnStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	s.db.execs = append(s.db.execs, fakeStmt{query: s.query, args: args})
+	onExec := s.db.onExec
+	s.db.mu.Unlock()
+	if onExec == nil {
+		return driver.RowsAffected(1), nil
+	}
+	return onExec(s.query, args)
+}
+
+func (s *fakeConnStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.mu.Lock()
+	s.db.queries = append(s.db.queries, fakeStmt{query: s.query, args: args})
+	onQuery := s.db.onQuery
+	s.db.mu.Unlock()
+	if onQuery == nil {
+		return &fakeDriverRows{}, nil
+	}
+	rows, err := onQuery(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeDriverRows{rows: rows}, nil
+}
+
+type fakeDriverRows struct {
+	rows *fakeRows
+	pos  int
+}
+
+func (r *fakeDriverRows) Columns() []string {
+	if r.rows == nil {
+		return nil
+	}
+	return r.rows.cols
+}
+
+func (r *fakeDriverRows) ColumnTypeDatabaseTypeName(index int) string {
+	if r.rows == nil || index >= len(r.rows.types) {
+		return ""
+	}
+	return r.rows.types[index]
+}
+
+func (r *fakeDriverRows) Close() error { return nil }
+
+func (r *fakeDriverRows) Next(dest []driver.Value) error {
+	if r.rows == nil || r.pos >= len(r.rows.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows.rows[r.pos])
+	r.pos++
+	return nil
+}
+
+// fakeInsertResult is the result of an insert with an auto increment id.
+type fakeInsertResult struct {
+	lastInsertId int64
+	rowsAffected int64
+}
+
+func (r fakeInsertResult) LastInsertId() (int64, error) { return r.lastInsertId, nil }
+func (r fakeInsertResult) RowsAffected() (int64, error) { return r.rowsAffected, nil }
@@ -0,0 +1,121 @@
+package orm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// bindContext returns db running its statements with ctx. ORMer values
+// other than ORM and ORMTran are returned unchanged.
+func bindContext(ctx context.Context, db ORMer) ORMer {
+	if ctx == nil {
+		return db
+	}
+	switch o := db.(type) {
+	case *ORM:
+		return o.WithContext(ctx)
+	case *ORMTran:
+		return o.WithContext(ctx)
+	}
+	return db
+}
+
+// checkModel reports an error instead of the reflection panics when T is not a struct.
+func checkModel[T any]() error {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if !isModelType(t) {
+		return fmt.Errorf("%s is not a model struct", t)
+	}
+	return nil
+}
+
+// Get loads the row of T's table with primary key pk.
+func Get[T any](ctx context.Context, db ORMer, pk interface{}) (*T, error) {
+	if err := checkModel[T](); err != nil {
+		return nil, err
+	}
+	ret := new(T)
+	if err := bindContext(ctx, db).SelectByPK(ret, pk); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// One loads the first row of query, or returns sql.ErrNoRows.
+func One[T any](ctx context.Context, db ORMer, query string, args ...interface{}) (*T, error) {
+	if err := checkModel[T](); err != nil {
+		return nil, err
+	}
+	ret := new(T)
+	if err := bindContext(ctx, db).SelectOne(ret, query, args...); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Find loads all rows of query together with their or relations.
+func Find[T any](ctx context.Context, db ORMer, query string, args ...interface{}) ([]*T, error) {
+	if err := checkModel[T](); err != nil {
+		return nil, err
+	}
+	ret := make([]*T, 0)
+	if err := bindContext(ctx, db).Select(&ret, query, args...); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Insert inserts s and sets its auto increment primary key.
+func Insert[T any](ctx context.Context, db ORMer, s *T, ignore bool) error {
+	if err := checkModel[T](); err != nil {
+		return err
+	}
+	return bindContext(ctx, db).Insert(s, ignore)
+}
+
+// InsertBatch inserts s and sets the auto increment primary keys.
+func InsertBatch[T any](ctx context.Context, db ORMer, s []*T, ignore bool) error {
+	if err := checkModel[T](); err != nil {
+		return err
+	}
+	list := make([]interface{}, len(s))
+	for i, v := range s {
+		list[i] = v
+	}
+	return bindContext(ctx, db).InsertBatch(list, ignore)
+}
+
+// Repository is a typed view of T's table over an ORM or an ORMTran.
+type Repository[T any] struct {
+	db ORMer
+}
+
+func NewRepository[T any](db ORMer) *Repository[T] {
+	return &Repository[T]{db: db}
+}
+
+// WithTx returns a repository for the same table running inside tx.
+func (r *Repository[T]) WithTx(tx *ORMTran) *Repository[T] {
+	return &Repository[T]{db: tx}
+}
+
+func (r *Repository[T]) Get(ctx context.Context, pk interface{}) (*T, error) {
+	return Get[T](ctx, r.db, pk)
+}
+
+func (r *Repository[T]) One(ctx context.Context, query string, args ...interface{}) (*T, error) {
+	return One[T](ctx, r.db, query, args...)
+}
+
+func (r *Repository[T]) Find(ctx context.Context, query string, args ...interface{}) ([]*T, error) {
+	return Find[T](ctx, r.db, query, args...)
+}
+
+func (r *Repository[T]) Insert(ctx context.Context, s *T, ignore bool) error {
+	return Insert[T](ctx, r.db, s, ignore)
+}
+
+func (r *Repository[T]) InsertBatch(ctx context.Context, s []*T, ignore bool) error {
+	return InsertBatch[T](ctx, r.db, s, ignore)
+}
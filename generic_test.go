@@ -0,0 +1,62 @@
+package orm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+)
+
+func TestGenericGetAndFind(t *testing.T) {
+	fdb := &fakeDB{onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+		if len(args) == 1 && args[0] == int64(404) {
+			return &fakeRows{cols: []string{"user_id", "name"}}, nil
+		}
+		return cursorUsers(2), nil
+	}}
+	o := newFakeORM(fdb)
+	ctx := context.Background()
+
+	u, err := Get[TestCursorUser](ctx, o, int64(1))
+	if err != nil || u.UserId != 1 {
+		t.Fatalf("unexpected get result %v %v", u, err)
+	}
+	if fdb.queries[0].query != "select * from test_cursor_user where user_id = ?" {
+		t.Fatalf("unexpected query %s", fdb.queries[0].query)
+	}
+	if _, err := Get[TestCursorUser](ctx, o, int64(404)); err != sql.ErrNoRows {
+		t.Fatalf("expected no rows, got %v", err)
+	}
+
+	repo := NewRepository[TestCursorUser](o)
+	users, err := repo.Find(ctx, "select * from test_cursor_user")
+	if err != nil || len(users) != 2 || users[1].UserId != 2 {
+		t.Fatalf("unexpected find result %v %v", users, err)
+	}
+
+	if _, err := Get[int](ctx, o, 1); err == nil {
+		t.Fatal("non struct type should be rejected")
+	}
+}
+
+func TestGenericInsertInTx(t *testing.T) {
+	fdb := &fakeDB{onExec: func(query string, args []driver.Value) (driver.Result, error) {
+		return fakeInsertResult{lastInsertId: 5, rowsAffected: 1}, nil
+	}}
+	o := newFakeORM(fdb)
+	tx, err := o.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository[TestCursorUser](o).WithTx(tx)
+	u := &TestCursorUser{Name: "a"}
+	if err := repo.Insert(context.Background(), u, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if u.UserId != 5 || fdb.commits != 1 {
+		t.Fatalf("unexpected insert result %v", u)
+	}
+}
@@ -0,0 +1,127 @@
+package orm
+
+import (
+	"reflect"
+)
+
+// Models implement the interfaces below to run code around their
+// statements. tx is the Tdx of the operation, the active ORMTran when there
+// is one, so hooks can run their own statements in the same transaction. A
+// non-nil error aborts the operation.
+//
+// ORM.Insert and ORM.InsertBatch run in a transaction of their own when the
+// model has an After hook, so that its error rolls the insert back, and so
+// do ORM.Update, ORM.Upsert and ORM.Delete.
+//
+// Upsert and Import in ImportUpsert mode run BeforeInsert on every row and
+// AfterInsert on the rows they inserted, never the update hooks.
+type BeforeInserter interface {
+	BeforeInsert(tx Tdx) error
+}
+
+type AfterInserter interface {
+	AfterInsert(tx Tdx) error
+}
+
+type BeforeUpdater interface {
+	BeforeUpdate(tx Tdx) error
+}
+
+type AfterUpdater interface {
+	AfterUpdate(tx Tdx) error
+}
+
+type BeforeDeleter interface {
+	BeforeDelete(tx Tdx) error
+}
+
+type AfterDeleter interface {
+	AfterDelete(tx Tdx) error
+}
+
+// AfterFinder runs after the columns of a row are read into the model,
+// before its relations are loaded.
+type AfterFinder interface {
+	AfterFind() error
+}
+
+var (
+	afterInserterType = reflect.TypeOf((*AfterInserter)(nil)).Elem()
+	afterUpdaterType  = reflect.TypeOf((*AfterUpdater)(nil)).Elem()
+	afterDeleterType  = reflect.TypeOf((*AfterDeleter)(nil)).Elem()
+)
+
+// hasAfterHook tells whether the model s, or the element of a batch, needs
+// a transaction for its After hook of type hook.
+func hasAfterHook(s interface{}, hook reflect.Type) bool {
+	if s == nil {
+		return false
+	}
+	t := reflect.TypeOf(s)
+	if t.Kind() != reflect.Ptr {
+		t = reflect.PtrTo(t)
+	}
+	return t.Implements(hook)
+}
+
+func beforeInsert(tdx Tdx, s interface{}) error {
+	if h, ok := s.(BeforeInserter); ok {
+		return h.BeforeInsert(tdx)
+	}
+	return nil
+}
+
+func afterInsert(tdx Tdx, s interface{}) error {
+	if h, ok := s.(AfterInserter); ok {
+		return h.AfterInsert(tdx)
+	}
+	return nil
+}
+
+func beforeUpdate(tdx Tdx, s interface{}) error {
+	if h, ok := s.(BeforeUpdater); ok {
+		return h.BeforeUpdate(tdx)
+	}
+	return nil
+}
+
+func afterUpdate(tdx Tdx, s interface{}) error {
+	if h, ok := s.(AfterUpdater); ok {
+		return h.AfterUpdate(tdx)
+	}
+	return nil
+}
+
+func beforeDelete(tdx Tdx, s interface{}) error {
+	if h, ok := s.(BeforeDeleter); ok {
+		return h.BeforeDelete(tdx)
+	}
+	return nil
+}
+
+func afterDelete(tdx Tdx, s interface{}) error {
+	if h, ok := s.(AfterDeleter); ok {
+		return h.AfterDelete(tdx)
+	}
+	return nil
+}
+
+func afterFind(v reflect.Value) error {
+	if h, ok := v.Interface().(AfterFinder); ok {
+		return h.AfterFind()
+	}
+	return nil
+}
+
+// inTx runs f in a transaction of its own, committed when f succeeds.
+func (o *ORM) inTx(f func(tx *ORMTran) error) error {
+	tx, err := o.Begin()
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,88 @@
+package orm
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+type TestHookUser struct {
+	UserId int64 `pk:"true" ai:"true"`
+	Name   string
+	Found  bool  `ignore:"true"`
+	Logged int64 `ignore:"true"`
+}
+
+var errNoName = errors.New("name is required")
+
+func (u *TestHookUser) BeforeInsert(tx Tdx) error {
+	if u.Name == "" {
+		return errNoName
+	}
+	return nil
+}
+
+func (u *TestHookUser) AfterInsert(tx Tdx) error {
+	if u.Name == "fail" {
+		return errors.New("audit failed")
+	}
+	_, err := tx.Exec("insert into audit (user_id) values(?)", u.UserId)
+	u.Logged = u.UserId
+	return err
+}
+
+func (u *TestHookUser) AfterFind() error {
+	u.Found = true
+	return nil
+}
+
+func TestInsertHooks(t *testing.T) {
+	fdb := &fakeDB{onExec: func(query string, args []driver.Value) (driver.Result, error) {
+		return fakeInsertResult{lastInsertId: 5, rowsAffected: int64(len(args))}, nil
+	}}
+	o := newFakeORM(fdb)
+	o.SetBatchOptions(BatchOptions{AutoIncrementIncrement: 1})
+
+	if err := o.Insert(&TestHookUser{}, false); err != errNoName {
+		t.Fatalf("BeforeInsert should abort the insert, got %v", err)
+	}
+	if len(fdb.execs) != 0 {
+		t.Fatal("nothing should be executed")
+	}
+
+	u := &TestHookUser{Name: "a"}
+	if err := o.Insert(u, false); err != nil {
+		t.Fatal(err)
+	}
+	if u.Logged != 5 || fdb.commits != 1 || len(fdb.execs) != 2 {
+		t.Fatalf("AfterInsert should run in the insert transaction, logged %d commits %d", u.Logged, fdb.commits)
+	}
+
+	rolls := fdb.rolls
+	if err := o.Insert(&TestHookUser{Name: "fail"}, false); err == nil || fdb.rolls != rolls+1 {
+		t.Fatalf("an AfterInsert error should roll the insert back, got %v", err)
+	}
+
+	users := []interface{}{&TestHookUser{Name: "b"}, &TestHookUser{Name: "c"}}
+	if err := o.InsertBatch(users, false); err != nil {
+		t.Fatal(err)
+	}
+	if users[1].(*TestHookUser).Logged == 0 || fdb.commits != 2 {
+		t.Fatal("AfterInsert should run for every row of a batch")
+	}
+}
+
+func TestAfterFind(t *testing.T) {
+	fdb := &fakeDB{onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+		return &fakeRows{cols: []string{"user_id", "name"}, rows: [][]driver.Value{{int64(1), []byte("a")}}}, nil
+	}}
+	o := newFakeORM(fdb)
+
+	var users []*TestHookUser
+	if err := o.Select(&users, "select * from test_hook_user"); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || !users[0].Found {
+		t.Fatal("AfterFind should run for every row")
+	}
+}
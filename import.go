@@ -0,0 +1,602 @@
+package orm
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ImportMode tells Import what to do with rows whose key already exists.
+type ImportMode int
+
+const (
+	// ImportInsert fails those rows.
+	ImportInsert ImportMode = iota
+	// ImportIgnore skips them with insert ignore.
+	ImportIgnore
+	// ImportUpsert overwrites them with insert ... on duplicate key update.
+	ImportUpsert
+)
+
+// DefaultImportChunkSize is the number of rows Import inserts per batch.
+const DefaultImportChunkSize = 1000
+
+type ImportOptions struct {
+	Mode ImportMode
+	// DryRun parses and validates the input without writing anything.
+	DryRun bool
+	// ChunkSize is the number of rows per InsertBatch, 0 means DefaultImportChunkSize.
+	ChunkSize int
+	// MaxErrors stops the import once that many rows failed, 0 never stops.
+	MaxErrors int
+}
+
+// ImportRowError is a row Import could not load. Line is the line of the
+// row in the input, header included.
+type ImportRowError struct {
+	Line int
+	Err  error
+}
+
+func (e *ImportRowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+type ImportResult struct {
+	// Rows is the number of rows parsed successfully.
+	Rows int64
+	// Inserted is the number of rows written, updated ones included.
+	Inserted int64
+	// Skipped is the number of rows ImportIgnore left out.
+	Skipped int64
+	Errors  []*ImportRowError
+}
+
+// ErrTooManyImportErrors is returned once ImportOptions.MaxErrors rows failed.
+var ErrTooManyImportErrors = errors.New("too many import errors")
+
+// importRecord is one parsed row: its values by header position, nil for null.
+type importRecord struct {
+	line   int
+	values []*string
+}
+
+// importReader reads the header and the records of an import.
+type importReader interface {
+	header() ([]string, error)
+	next() (*importRecord, error)
+}
+
+type csvImportReader struct {
+	r *csv.Reader
+}
+
+func (c *csvImportReader) header() ([]string, error) {
+	h, err := c.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(h) > 0 {
+		h[0] = strings.TrimPrefix(h[0], "\ufeff")
+	}
+	return h, nil
+}
+
+// next treats empty fields as null, which is how ExportQuery writes NULL.
+func (c *csvImportReader) next() (*importRecord, error) {
+	rec, err := c.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	line, _ := c.r.FieldPos(0)
+	ret := &importRecord{line: line, values: make([]*string, len(rec))}
+	for k := range rec {
+		if rec[k] != "" {
+			ret.values[k] = &rec[k]
+		}
+	}
+	return ret, nil
+}
+
+type jsonImportReader struct {
+	r    *bufio.Reader
+	line int
+	cols []string
+	// first is the record read to find the columns
+	first *importRecord
+	index map[string]int
+}
+
+func (j *jsonImportReader) readObject() (map[string]interface{}, error) {
+	for {
+		b, err := j.r.ReadBytes('\n')
+		if len(bytes.TrimSpace(b)) == 0 {
+			if err != nil {
+				return nil, err
+			}
+			j.line++
+			continue
+		}
+		j.line++
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		var obj map[string]interface{}
+		if derr := d.Decode(&obj); derr != nil {
+			return nil, &ImportRowError{Line: j.line, Err: derr}
+		}
+		return obj, nil
+	}
+}
+
+// header takes the keys of the first object, later objects may not add any.
+func (j *jsonImportReader) header() ([]string, error) {
+	obj, err := j.readObject()
+	if err != nil {
+		return nil, err
+	}
+	j.index = make(map[string]int, len(obj))
+	for k := range obj {
+		j.index[k] = len(j.cols)
+		j.cols = append(j.cols, k)
+	}
+	j.first, err = j.record(obj)
+	return j.cols, err
+}
+
+func (j *jsonImportReader) next() (*importRecord, error) {
+	if j.first != nil {
+		ret := j.first
+		j.first = nil
+		return ret, nil
+	}
+	obj, err := j.readObject()
+	if err != nil {
+		return nil, err
+	}
+	return j.record(obj)
+}
+
+func (j *jsonImportReader) record(obj map[string]interface{}) (*importRecord, error) {
+	ret := &importRecord{line: j.line, values: make([]*string, len(j.cols))}
+	for k, v := range obj {
+		i, ok := j.index[k]
+		if !ok {
+			return nil, &ImportRowError{Line: j.line, Err: errors.New("unknown key " + k)}
+		}
+		var text string
+		switch x := v.(type) {
+		case nil:
+			continue
+		case string:
+			text = x
+		case json.Number:
+			text = x.String()
+		case bool:
+			text = strconv.FormatBool(x)
+		default:
+			b, err := json.Marshal(x)
+			if err != nil {
+				return nil, &ImportRowError{Line: j.line, Err: err}
+			}
+			text = string(b)
+		}
+		ret.values[i] = &text
+	}
+	return ret, nil
+}
+
+func newImportReader(r io.Reader, format ExportFormat) (importReader, error) {
+	switch format {
+	case ExportCSV, ExportTSV:
+		cr := csv.NewReader(r)
+		if format == ExportTSV {
+			cr.Comma = '\t'
+			cr.LazyQuotes = true
+		}
+		return &csvImportReader{r: cr}, nil
+	case ExportJSONL:
+		return &jsonImportReader{r: bufio.NewReader(r)}, nil
+	}
+	return nil, errors.New("unsupported import format: " + string(format))
+}
+
+var importTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02"}
+
+func parseImportTime(text string) (time.Time, error) {
+	var err error
+	for _, layout := range importTimeLayouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, text, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+var nullTimeType = reflect.TypeOf(sql.NullTime{})
+
+// setImportField stores text, nil for null, into fv.
+func setImportField(fv reflect.Value, text *string) error {
+	if fv.Kind() == reflect.Ptr {
+		if text == nil {
+			fv.Set(reflect.Zero(fv.Type()))
+			return nil
+		}
+		v := reflect.New(fv.Type().Elem())
+		if err := setImportField(v.Elem(), text); err != nil {
+			return err
+		}
+		fv.Set(v)
+		return nil
+	}
+	if fv.Type() == nullTimeType && text != nil {
+		t, err := parseImportTime(*text)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(sql.NullTime{Time: t, Valid: true}))
+		return nil
+	}
+	if scanner, ok := fv.Addr().Interface().(sql.Scanner); ok && fv.Type() != timeType {
+		if text == nil {
+			return scanner.Scan(nil)
+		}
+		return scanner.Scan(*text)
+	}
+	if text == nil {
+		if fv.Kind() == reflect.String {
+			fv.SetString("")
+			return nil
+		}
+		return errors.New("empty value for non-nullable " + fv.Type().String())
+	}
+	s := *text
+	if fv.Type() == timeType {
+		t, err := parseImportTime(s)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.Uint8 {
+			return errors.New("can not import into " + fv.Type().String())
+		}
+		fv.SetBytes([]byte(s))
+	default:
+		return errors.New("can not import into " + fv.Type().String())
+	}
+	return nil
+}
+
+// importFields maps the header to the field indexes of t, checking every
+// column against the table and the struct.
+func importFields(tdx Tdx, t reflect.Type, table string, header []string) ([][]int, error) {
+	tableCols, err := getColumns(tdx, table)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(tableCols))
+	for _, c := range tableCols {
+		known[c] = true
+	}
+	ret := make([][]int, len(header))
+	for k, h := range header {
+		col := h
+		if strings.ToLower(h) != h {
+			// a field name, like the keys of SelectRawSet
+			col = fieldName2ColName(h)
+		}
+		if !known[col] {
+			return nil, errors.New(table + " has no column " + col)
+		}
+		f, ok := fieldByColumn(t, col)
+		if !ok || f.Tag.Get("or") != "" || f.Tag.Get("ignore") == "true" {
+			return nil, errors.New(t.Name() + " has no importable field for column " + col)
+		}
+		if f.Tag.Get("pk") == "true" && f.Tag.Get("ai") == "true" {
+			// the insert never writes it, the value would be silently lost
+			return nil, errors.New("column " + col + " is the auto increment primary key of " + table + ", it cannot be imported")
+		}
+		ret[k] = f.Index
+	}
+	return ret, nil
+}
+
+// upsertBatch inserts s, already prepared, updating the columns in update
+// of rows whose key exists like upsert does. Unlike upsert it does not read
+// back ids and versions, Import does not hand its rows out.
+func upsertBatch(tdx Tdx, s []interface{}, update map[string]bool) (int64, error) {
+	t := reflect.TypeOf(s[0]).Elem()
+	cols, vals, ifs, _, _ := columnsBySlice(s)
+	table := GetMapTable(fieldName2ColName(t.Name()))
+	q := fmt.Sprintf("insert ignore into %s %s values %s", table, cols, vals)
+	if sets := upsertSets(t, update); len(sets) > 0 {
+		q = fmt.Sprintf("insert into %s %s values %s on duplicate key update %s", table, cols, vals, strings.Join(sets, ","))
+	}
+	ret, err := tdx.Exec(q, ifs...)
+	if err != nil {
+		return 0, err
+	}
+	return ret.RowsAffected()
+}
+
+// upsertChunk upserts chunk, already prepared, and returns the number of
+// rows written and, for AfterInsert, the indexes of the rows not inserted.
+// MySQL does not tell which rows of a multi-row upsert were inserted, the
+// rows of a model with AfterInsert are sent one by one.
+func upsertChunk(tdx Tdx, chunk []interface{}, update map[string]bool) (int64, []int, error) {
+	if !hasAfterHook(chunk[0], afterInserterType) {
+		// affected rows count updated rows twice
+		if _, err := upsertBatch(tdx, chunk, update); err != nil {
+			return 0, nil, err
+		}
+		return int64(len(chunk)), nil, nil
+	}
+	var written int64
+	var updated []int
+	for i := range chunk {
+		n, err := upsertBatch(tdx, chunk[i:i+1], update)
+		if err != nil {
+			return written, updated, err
+		}
+		if n != 1 {
+			updated = append(updated, i)
+		}
+		written++
+	}
+	return written, updated, nil
+}
+
+// writeImportChunk writes rows, the rows read from lines, in the
+// transaction tdx. Every row is prepared once; when a chunk fails it is
+// rolled back to its savepoint and its rows retried one by one to find the
+// bad ones.
+func writeImportChunk(tdx Tdx, h *queryHooks, rows []interface{}, lines []int, update map[string]bool, opts *ImportOptions, res *ImportResult) error {
+	prepare := prepareInsert
+	if opts.Mode == ImportUpsert {
+		prepare = prepareUpsert
+	}
+	now := h.now()
+	ready := make([]interface{}, 0, len(rows))
+	readyLines := make([]int, 0, len(lines))
+	for i, r := range rows {
+		if err := prepare(tdx, now, rows[i:i+1]); err != nil {
+			if ferr := res.fail(opts, lines[i], err); ferr != nil {
+				return ferr
+			}
+			continue
+		}
+		ready = append(ready, r)
+		readyLines = append(readyLines, lines[i])
+	}
+	if len(ready) == 0 {
+		return nil
+	}
+
+	var inserted, skipped int64
+	write := func(chunk []interface{}) error {
+		inserted, skipped = 0, 0
+		var skippedRows []int
+		var err error
+		switch opts.Mode {
+		case ImportUpsert:
+			inserted, skippedRows, err = upsertChunk(tdx, chunk, update)
+		case ImportIgnore:
+			inserted, skippedRows, err = insertChunk(tdx, h, chunk, true, false)
+			skipped = int64(len(chunk)) - inserted
+		default:
+			inserted, _, err = insertChunk(tdx, h, chunk, false, false)
+		}
+		if err != nil {
+			return err
+		}
+		return afterInsertRows(tdx, chunk, skippedRows)
+	}
+	offset := 0
+	for _, chunk := range splitBatch(ready, h.batch) {
+		err := inSavepoint(tdx, "orm_import", func() error { return write(chunk) })
+		if err == nil {
+			res.Inserted += inserted
+			res.Skipped += skipped
+		} else {
+			for i := range chunk {
+				if len(chunk) > 1 {
+					err = inSavepoint(tdx, "orm_import", func() error { return write(chunk[i : i+1]) })
+				}
+				if err != nil {
+					if ferr := res.fail(opts, readyLines[offset+i], err); ferr != nil {
+						return ferr
+					}
+					continue
+				}
+				res.Inserted += inserted
+				res.Skipped += skipped
+			}
+		}
+		offset += len(chunk)
+	}
+	return nil
+}
+
+func (res *ImportResult) fail(opts *ImportOptions, line int, err error) error {
+	res.Errors = append(res.Errors, &ImportRowError{Line: line, Err: err})
+	if opts.MaxErrors > 0 && len(res.Errors) >= opts.MaxErrors {
+		return ErrTooManyImportErrors
+	}
+	return nil
+}
+
+// importRows reads the rows of r into the table of s. chunkTx runs the
+// writes of every chunk in a transaction.
+func importRows(tdx Tdx, h *queryHooks, s interface{}, r io.Reader, format ExportFormat, opts ImportOptions, chunkTx func(func(Tdx) error) error) (*ImportResult, error) {
+	t := reflect.TypeOf(s)
+	if t == nil || t.Kind() != reflect.Ptr || !isModelType(t.Elem()) {
+		return nil, fmt.Errorf("import needs a pointer to a model struct, got %T", s)
+	}
+	t = t.Elem()
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultImportChunkSize
+	}
+	ir, err := newImportReader(r, format)
+	if err != nil {
+		return nil, err
+	}
+	header, err := ir.header()
+	if err == io.EOF {
+		return &ImportResult{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields, err := importFields(tdx, t, GetMapTable(fieldName2ColName(t.Name())), header)
+	if err != nil {
+		return nil, err
+	}
+
+	// an upsert only overwrites the columns of the input
+	update := make(map[string]bool, len(fields))
+	for _, idx := range fields {
+		update[columnName(t.FieldByIndex(idx))] = true
+	}
+
+	res := &ImportResult{}
+	chunk := make([]interface{}, 0, opts.ChunkSize)
+	lines := make([]int, 0, opts.ChunkSize)
+	flush := func() error {
+		if len(chunk) == 0 || opts.DryRun {
+			chunk, lines = chunk[:0], lines[:0]
+			return nil
+		}
+		inserted, skipped := res.Inserted, res.Skipped
+		var stop error
+		err := chunkTx(func(tdx Tdx) error {
+			stop = writeImportChunk(tdx, h, chunk, lines, update, &opts, res)
+			if stop == ErrTooManyImportErrors {
+				// keep the rows written before
+				return nil
+			}
+			return stop
+		})
+		if err != nil {
+			res.Inserted, res.Skipped = inserted, skipped
+		} else {
+			err = stop
+		}
+		chunk = make([]interface{}, 0, opts.ChunkSize)
+		lines = lines[:0]
+		return err
+	}
+	for {
+		rec, err := ir.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var rowErr *ImportRowError
+			var parseErr *csv.ParseError
+			switch {
+			case errors.As(err, &rowErr):
+				err = res.fail(&opts, rowErr.Line, rowErr.Err)
+			case errors.As(err, &parseErr):
+				err = res.fail(&opts, parseErr.Line, parseErr.Err)
+			}
+			if err != nil {
+				return res, err
+			}
+			continue
+		}
+		v := reflect.New(t)
+		var rowErr error
+		for k, idx := range fields {
+			if err := setImportField(v.Elem().FieldByIndex(idx), rec.values[k]); err != nil {
+				rowErr = fmt.Errorf("%s: %v", header[k], err)
+				break
+			}
+		}
+		if rowErr != nil {
+			if err := res.fail(&opts, rec.line, rowErr); err != nil {
+				return res, err
+			}
+			continue
+		}
+		res.Rows++
+		chunk = append(chunk, v.Interface())
+		lines = append(lines, rec.line)
+		if len(chunk) == opts.ChunkSize {
+			if err := flush(); err != nil {
+				return res, err
+			}
+		}
+	}
+	return res, flush()
+}
+
+// Import loads CSV, JSON Lines or TSV rows, as written by ExportQuery, into
+// the table of s, a pointer to a registered model. Headers are column names
+// or field names; fields the input lacks are inserted with their zero value
+// and left alone by ImportUpsert. As with InsertBatch an auto increment
+// primary key is never written, so an input with its column is rejected
+// and ImportUpsert matches on unique keys.
+// Rows that fail to parse or insert are reported in ImportResult.Errors.
+// Every chunk is written in a transaction of its own.
+func (o *ORM) Import(s interface{}, r io.Reader, format ExportFormat, opts ImportOptions) (*ImportResult, error) {
+	tdx, span := o.startOp("Import", getTableName(s), "")
+	res, err := importRows(tdx, o.hooks, s, r, format, opts, func(f func(Tdx) error) error {
+		return o.inTx(func(tx *ORMTran) error { return f(tx.tdx()) })
+	})
+	if res != nil {
+		span.end(res.Inserted, err)
+	} else {
+		span.end(-1, err)
+	}
+	return res, err
+}
+
+func (o *ORMTran) Import(s interface{}, r io.Reader, format ExportFormat, opts ImportOptions) (*ImportResult, error) {
+	tdx, span := o.startOp("Import", getTableName(s), "")
+	res, err := importRows(tdx, o.hooks, s, r, format, opts, func(f func(Tdx) error) error { return f(tdx) })
+	if res != nil {
+		span.end(res.Inserted, err)
+	} else {
+		span.end(-1, err)
+	}
+	return res, err
+}
@@ -0,0 +1,213 @@
+package orm
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func importDB() *fakeDB {
+	return &fakeDB{onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+		if strings.Contains(query, "auto_increment_increment") {
+			return &fakeRows{cols: []string{"@@auto_increment_increment"}, rows: [][]driver.Value{{int64(1)}}}, nil
+		}
+		ret := &fakeRows{cols: []string{"Field", "Type", "Null", "Key", "Default", "Extra"}}
+		for _, c := range []string{"book_id", "user_id", "title"} {
+			ret.rows = append(ret.rows, []driver.Value{c, "", "", "", nil, ""})
+		}
+		return ret, nil
+	}}
+}
+
+func TestImportCSV(t *testing.T) {
+	fdb := importDB()
+	fdb.onExec = func(query string, args []driver.Value) (driver.Result, error) {
+		for _, a := range args {
+			if a == "dup" {
+				return nil, errors.New("duplicate entry")
+			}
+		}
+		return fakeInsertResult{lastInsertId: 1, rowsAffected: int64(len(args) / 2)}, nil
+	}
+	o := newFakeORM(fdb)
+	in := "\ufeffuser_id,Title\n1,a\nx,b\n2,dup\n3,c\n"
+	res, err := o.Import(&TestCursorBook{}, strings.NewReader(in), ExportCSV, ImportOptions{ChunkSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Rows != 3 || res.Inserted != 2 || len(res.Errors) != 2 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if res.Errors[0].Line != 3 || res.Errors[1].Line != 4 {
+		t.Fatalf("unexpected error lines %v %v", res.Errors[0], res.Errors[1])
+	}
+	// the failing batch of two is retried row by row
+	if len(fdb.writes()) != 4 || fdb.commits != 2 {
+		t.Fatalf("unexpected statements %v", fdb.execQueries())
+	}
+}
+
+func TestImportUpsertAndDryRun(t *testing.T) {
+	fdb := importDB()
+	o := newFakeORM(fdb)
+	in := `{"user_id":1,"title":"a"}` + "\n\n" + `{"user_id":2,"title":null}` + "\n"
+	res, err := o.Import(&TestCursorBook{}, strings.NewReader(in), ExportJSONL, ImportOptions{Mode: ImportUpsert})
+	if err != nil || res.Inserted != 2 {
+		t.Fatalf("unexpected result %+v %v", res, err)
+	}
+	q := fdb.writes()[0].query
+	if !strings.HasSuffix(q, "on duplicate key update user_id = values(user_id),title = values(title)") {
+		t.Fatalf("unexpected upsert %s", q)
+	}
+
+	fdb.execs = nil
+	in = "user_id\ttitle\n1\ta\n"
+	res, err = o.Import(&TestCursorBook{}, strings.NewReader(in), ExportTSV, ImportOptions{DryRun: true})
+	if err != nil || res.Rows != 1 || res.Inserted != 0 || len(fdb.execs) != 0 {
+		t.Fatalf("dry run should not write, got %+v %v", res, err)
+	}
+
+	if _, err := o.Import(&TestCursorBook{}, strings.NewReader("isbn\n1\n"), ExportCSV, ImportOptions{}); err == nil {
+		t.Fatal("unknown column should be rejected")
+	}
+	if _, err := o.Import(&TestCursorBook{}, strings.NewReader("book_id,title\n1,a\n"), ExportCSV, ImportOptions{Mode: ImportUpsert}); err == nil || !strings.Contains(err.Error(), "auto increment primary key") {
+		t.Fatalf("auto increment primary key column should be rejected, got %v", err)
+	}
+}
+
+type TestImportItem struct {
+	Code      string `pk:"true"`
+	Name      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Version   int64 `version:"true"`
+}
+
+func (i *TestImportItem) BeforeInsert(tx Tdx) error {
+	i.Name = strings.TrimSpace(i.Name)
+	return nil
+}
+
+func TestImportUpsertTimes(t *testing.T) {
+	fdb := &fakeDB{onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+		ret := &fakeRows{cols: []string{"Field", "Type", "Null", "Key", "Default", "Extra"}}
+		for _, c := range []string{"code", "name", "created_at", "updated_at", "version"} {
+			ret.rows = append(ret.rows, []driver.Value{c, "", "", "", nil, ""})
+		}
+		return ret, nil
+	}}
+	o := newFakeORM(fdb)
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	o.SetClock(func() time.Time { return now })
+
+	if _, err := o.Import(&TestImportItem{}, strings.NewReader("code,name\nx, a \n"), ExportCSV, ImportOptions{Mode: ImportUpsert}); err != nil {
+		t.Fatal(err)
+	}
+	e := fdb.writes()[0]
+	if !strings.HasSuffix(e.query, "on duplicate key update name = values(name),updated_at = values(updated_at),version = version + 1") {
+		t.Fatalf("unexpected upsert %s", e.query)
+	}
+	if e.args[1] != "a" || e.args[2] != now || e.args[3] != now || e.args[4] != int64(1) {
+		t.Fatalf("hooks, timestamps and versions should apply to upserted rows, got %v", e.args)
+	}
+}
+
+type TestImportEvent struct {
+	EventId int64 `pk:"true" ai:"true"`
+	Name    string
+}
+
+var importEventHooks = map[string]int{}
+
+func (e *TestImportEvent) BeforeInsert(tx Tdx) error {
+	importEventHooks["before "+e.Name]++
+	return nil
+}
+
+func (e *TestImportEvent) AfterInsert(tx Tdx) error {
+	importEventHooks["after "+e.Name]++
+	return nil
+}
+
+func TestImportRetryWritesRowsOnce(t *testing.T) {
+	importEventHooks = map[string]int{}
+	var stored, saved []string
+	fdb := &fakeDB{
+		onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+			return &fakeRows{cols: []string{"Field", "Type", "Null", "Key", "Default", "Extra"}, rows: [][]driver.Value{
+				{"event_id", "", "", "", nil, ""}, {"name", "", "", "", nil, ""},
+			}}, nil
+		},
+		onExec: func(query string, args []driver.Value) (driver.Result, error) {
+			switch {
+			case strings.HasPrefix(query, "savepoint"):
+				saved = append([]string{}, stored...)
+			case strings.HasPrefix(query, "rollback to savepoint"):
+				stored = saved
+			case !strings.HasPrefix(query, "insert"):
+			case args[0] == "bad":
+				return nil, errors.New("data too long")
+			default:
+				stored = append(stored, args[0].(string))
+				return fakeInsertResult{lastInsertId: int64(len(stored)), rowsAffected: 1}, nil
+			}
+			return driver.RowsAffected(0), nil
+		},
+	}
+	o := newFakeORM(fdb)
+	res, err := o.Import(&TestImportEvent{}, strings.NewReader("name\na\nbad\nc\n"), ExportCSV, ImportOptions{Mode: ImportIgnore})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Inserted != 2 || res.Skipped != 0 || len(res.Errors) != 1 || res.Errors[0].Line != 3 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if strings.Join(stored, ",") != "a,c" {
+		t.Fatalf("every good row should be written once, got %v", stored)
+	}
+	for _, name := range []string{"a", "bad", "c"} {
+		if importEventHooks["before "+name] != 1 {
+			t.Fatalf("BeforeInsert of %s ran %d times", name, importEventHooks["before "+name])
+		}
+	}
+	if importEventHooks["after a"] != 1 || importEventHooks["after c"] != 1 || importEventHooks["after bad"] != 0 {
+		t.Fatalf("AfterInsert should run once per written row, got %v", importEventHooks)
+	}
+}
+
+func TestUpsertAfterInsertOnlyForInsertedRows(t *testing.T) {
+	importEventHooks = map[string]int{}
+	fdb := &fakeDB{
+		onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+			return &fakeRows{cols: []string{"Field", "Type", "Null", "Key", "Default", "Extra"}, rows: [][]driver.Value{
+				{"event_id", "", "", "", nil, ""}, {"name", "", "", "", nil, ""},
+			}}, nil
+		},
+		onExec: func(query string, args []driver.Value) (driver.Result, error) {
+			if strings.HasPrefix(query, "insert") && args[0] == "old" {
+				return fakeInsertResult{lastInsertId: 1, rowsAffected: 2}, nil
+			}
+			return fakeInsertResult{lastInsertId: 2, rowsAffected: 1}, nil
+		},
+	}
+	o := newFakeORM(fdb)
+	res, err := o.Import(&TestImportEvent{}, strings.NewReader("name\nold\nnew\n"), ExportCSV, ImportOptions{Mode: ImportUpsert})
+	if err != nil || res.Inserted != 2 {
+		t.Fatalf("unexpected result %+v %v", res, err)
+	}
+	if len(fdb.writes()) != 2 {
+		t.Fatalf("rows with AfterInsert should be upserted one by one, got %v", fdb.execQueries())
+	}
+	if err := o.Upsert(&TestImportEvent{Name: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Upsert(&TestImportEvent{Name: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if importEventHooks["before old"] != 2 || importEventHooks["before new"] != 2 ||
+		importEventHooks["after old"] != 0 || importEventHooks["after new"] != 2 {
+		t.Fatalf("AfterInsert should only run for inserted rows, got %v", importEventHooks)
+	}
+}
@@ -0,0 +1,135 @@
+package orm
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"time"
+)
+
+// Op tells an Interceptor which Tdx method a statement is sent through.
+type Op string
+
+const (
+	OpExec  Op = "exec"
+	OpQuery Op = "query"
+)
+
+// Handler sends a statement on. For OpExec only the sql.Result is set, for
+// OpQuery only the *sql.Rows.
+type Handler func(ctx context.Context, op Op, query string, args []interface{}) (sql.Result, *sql.Rows, error)
+
+// Interceptor wraps every Exec and Query the ORM sends to the database,
+// including the queries issued to load relations. It may change the
+// query and args before calling next, or return without calling it.
+type Interceptor func(ctx context.Context, op Op, query string, args []interface{}, next Handler) (sql.Result, *sql.Rows, error)
+
+// queryHooks holds the settings shared by an ORM and the ORMTran values it begins.
+type queryHooks struct {
+	interceptors  []Interceptor
+	logger        QueryLogger
+	slowThreshold time.Duration
+	redactArgs    bool
+	metrics       MetricsCollector
+	tracer        Tracer
+	batch         BatchOptions
+	aiIncrement   atomic.Int64
+	rawFormat     *RawFormat
+	dialect       Dialect
+	clock         Clock
+}
+
+// Use appends interceptors to the chain, the first one registered is the outermost.
+// The chain applies to the ORMTran values begun afterwards as well.
+func (o *ORM) Use(interceptors ...Interceptor) {
+	o.hooks.interceptors = append(o.hooks.interceptors, interceptors...)
+}
+
+// WithContext returns a shallow copy of o whose statements run with ctx.
+func (o *ORM) WithContext(ctx context.Context) *ORM {
+	ret := *o
+	ret.ctx = ctx
+	return &ret
+}
+
+// WithContext returns a shallow copy of o whose statements run with ctx.
+func (o *ORMTran) WithContext(ctx context.Context) *ORMTran {
+	ret := *o
+	ret.ctx = ctx
+	return &ret
+}
+
+// rawTdx is implemented by both *sql.DB and *sql.Tx.
+type rawTdx interface {
+	Tdx
+	ExecContext(context.Context, string, ...interface{}) (sql.Result, error)
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+}
+
+func (h *queryHooks) wrap(ctx context.Context, tdx rawTdx) Tdx {
+	if h == nil || (ctx == nil && h.logger == nil && h.metrics == nil && h.tracer == nil && len(h.interceptors) == 0) {
+		return sliceArgsTdx{tdx}
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &hookedTdx{ctx: ctx, tdx: tdx, hooks: h}
+}
+
+func (h *queryHooks) invoke(ctx context.Context, op Op, query string, args []interface{}, tdx rawTdx) (sql.Result, *sql.Rows, error) {
+	next := func(ctx context.Context, op Op, query string, args []interface{}) (sql.Result, *sql.Rows, error) {
+		ctx, span := h.startStatement(ctx, query)
+		start := time.Now()
+		if op == OpQuery {
+			rows, err := tdx.QueryContext(ctx, query, args...)
+			h.done(query, args, time.Since(start), -1, err)
+			span.end(-1, err)
+			return nil, rows, err
+		}
+		ret, err := tdx.ExecContext(ctx, query, args...)
+		var ra int64 = -1
+		if err == nil {
+			if n, rerr := ret.RowsAffected(); rerr == nil {
+				ra = n
+			}
+		}
+		h.done(query, args, time.Since(start), ra, err)
+		span.end(ra, err)
+		return ret, nil, err
+	}
+	for i := len(h.interceptors) - 1; i >= 0; i-- {
+		next = chainInterceptor(h.interceptors[i], next)
+	}
+	return next(ctx, op, query, args)
+}
+
+func (h *queryHooks) done(query string, args []interface{}, d time.Duration, rowsAffected int64, err error) {
+	h.log(query, args, d, rowsAffected, err)
+	h.observeQuery(query, d, err)
+}
+
+func chainInterceptor(in Interceptor, next Handler) Handler {
+	return func(ctx context.Context, op Op, query string, args []interface{}) (sql.Result, *sql.Rows, error) {
+		return in(ctx, op, query, args, next)
+	}
+}
+
+// hookedTdx sends every statement through the interceptor chain and the
+// query logger before it reaches the wrapped *sql.DB or *sql.Tx.
+type hookedTdx struct {
+	ctx   context.Context
+	tdx   rawTdx
+	hooks *queryHooks
+}
+
+func (h *hookedTdx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	query, args = expandSliceArgs(query, args)
+	ret, _, err := h.hooks.invoke(h.ctx, OpExec, query, args, h.tdx)
+	return ret, err
+}
+
+func (h *hookedTdx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	query, args = expandSliceArgs(query, args)
+	_, rows, err := h.hooks.invoke(h.ctx, OpQuery, query, args, h.tdx)
+	return rows, err
+}
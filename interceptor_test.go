@@ -0,0 +1,101 @@
+package orm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type ctxKey string
+
+func TestInterceptorChain(t *testing.T) {
+	var order []string
+	trace := func(name string) Interceptor {
+		return func(ctx context.Context, op Op, query string, args []interface{}, next Handler) (sql.Result, *sql.Rows, error) {
+			order = append(order, name+":"+string(op))
+			return next(ctx, op, query, args)
+		}
+	}
+	tenant := func(ctx context.Context, op Op, query string, args []interface{}, next Handler) (sql.Result, *sql.Rows, error) {
+		if ctx.Value(ctxKey("tenant")) != "t1" {
+			t.Fatal("context should reach the interceptors")
+		}
+		return next(ctx, op, query+" and tenant_id = ?", append(args, "t1"))
+	}
+	hooks := &queryHooks{interceptors: []Interceptor{trace("outer"), trace("inner"), tenant}}
+	fake := &fakeDB{}
+	ctx := context.WithValue(context.Background(), ctxKey("tenant"), "t1")
+	tdx := hooks.wrap(ctx, fake.open())
+
+	if _, err := tdx.Exec("delete from user where id = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	tdx.Query("select * from user where id = ?", 1)
+	if strings.Join(order, ",") != "outer:exec,inner:exec,outer:query,inner:query" {
+		t.Fatalf("unexpected order %v", order)
+	}
+	if e := fake.execs[0]; e.query != "delete from user where id = ? and tenant_id = ?" || len(e.args) != 2 {
+		t.Fatalf("query should be rewritten, got %s %v", e.query, e.args)
+	}
+}
+
+func TestInterceptorShortCircuit(t *testing.T) {
+	errOpen := errors.New("circuit open")
+	hooks := &queryHooks{interceptors: []Interceptor{
+		func(ctx context.Context, op Op, query string, args []interface{}, next Handler) (sql.Result, *sql.Rows, error) {
+			return nil, nil, errOpen
+		},
+	}}
+	fake := &fakeDB{}
+	if _, err := hooks.wrap(nil, fake.open()).Exec("update user set name = ?", "a"); err != errOpen {
+		t.Fatalf("expected %v, got %v", errOpen, err)
+	}
+	if len(fake.execs) != 0 {
+		t.Fatal("statement should not reach the database")
+	}
+}
+
+// relationDB answers the queries loading a TestSoftUser and its books.
+func relationDB() *fakeDB {
+	return &fakeDB{onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+		if strings.Contains(query, "test_soft_book") {
+			return &fakeRows{cols: []string{"book_id", "user_id", "removed"}, rows: [][]driver.Value{{int64(7), int64(1), nil}}}, nil
+		}
+		return &fakeRows{cols: []string{"user_id", "name", "deleted_at"}, rows: [][]driver.Value{{int64(1), []byte("a"), nil}}}, nil
+	}}
+}
+
+func TestInterceptorSeesRelationQueries(t *testing.T) {
+	var seen []string
+	o := newFakeORM(relationDB())
+	o.SetTracer(&SpanRecorder{})
+	o.Use(func(ctx context.Context, op Op, query string, args []interface{}, next Handler) (sql.Result, *sql.Rows, error) {
+		span, _ := ctx.Value(recordedSpanKey{}).(*RecordedSpan)
+		if span == nil {
+			t.Fatalf("%s should run in the span of its call", query)
+		}
+		seen = append(seen, span.Name+": "+query)
+		return next(ctx, op, query, args)
+	})
+
+	u := &TestSoftUser{}
+	if err := o.SelectOne(u, "select * from test_soft_user where user_id = ?", 1); err != nil || len(u.Books) != 1 {
+		t.Fatalf("unexpected user %+v %v", u, err)
+	}
+	tx, err := o.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.SelectOne(u, "select * from test_soft_user where user_id = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	tx.Commit()
+
+	child := "orm.SelectOne: SELECT * FROM test_soft_book WHERE user_id = ? and removed is null"
+	if len(seen) != 4 || seen[1] != child || seen[3] != child {
+		t.Fatalf("relation queries should go through the interceptors, got %v", seen)
+	}
+}
@@ -0,0 +1,121 @@
+package orm
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// QueryEvent describes one statement the ORM sent to the database.
+type QueryEvent struct {
+	Query string
+	// Args are the bound arguments, or redacted placeholders when
+	// SetRedactArgs(true) is in effect.
+	Args     []interface{}
+	Duration time.Duration
+	// RowsAffected is -1 for queries and for results that can not report it.
+	RowsAffected int64
+	Err          error
+	// Slow is set when a slow-query threshold is configured and the
+	// statement took at least that long.
+	Slow bool
+	// Warning notes a likely mistake in a statement about to run. Such
+	// events come on their own, before the event of the statement.
+	Warning string
+}
+
+// QueryLogger receives every statement executed through an ORM or ORMTran.
+type QueryLogger interface {
+	LogQuery(e *QueryEvent)
+}
+
+// QueryLoggerFunc adapts a plain function to QueryLogger.
+type QueryLoggerFunc func(e *QueryEvent)
+
+func (f QueryLoggerFunc) LogQuery(e *QueryEvent) {
+	f(e)
+}
+
+type stdQueryLogger struct {
+	l *log.Logger
+}
+
+// NewStdQueryLogger returns a QueryLogger writing to l, or to stderr when l is nil.
+func NewStdQueryLogger(l *log.Logger) QueryLogger {
+	if l == nil {
+		l = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	return &stdQueryLogger{l: l}
+}
+
+func (s *stdQueryLogger) LogQuery(e *QueryEvent) {
+	prefix := "[orm]"
+	if e.Slow {
+		prefix = "[orm][slow]"
+	}
+	if e.Warning != "" {
+		s.l.Printf("[orm][warn] %s %v: %s", e.Query, e.Args, e.Warning)
+		return
+	}
+	if e.Err != nil {
+		s.l.Printf("%s %s %v (%v) error: %v", prefix, e.Query, e.Args, e.Duration, e.Err)
+		return
+	}
+	s.l.Printf("%s %s %v (%v, rows %d)", prefix, e.Query, e.Args, e.Duration, e.RowsAffected)
+}
+
+const redactedArg = "<redacted>"
+
+// SetQueryLogger sets the logger receiving every statement, nil disables logging.
+func (o *ORM) SetQueryLogger(l QueryLogger) {
+	o.hooks.logger = l
+}
+
+// SetSlowQueryThreshold makes the query logger only receive statements
+// taking at least d, plus failed ones. Zero logs every statement.
+func (o *ORM) SetSlowQueryThreshold(d time.Duration) {
+	o.hooks.slowThreshold = d
+}
+
+// SetRedactArgs hides argument values from the query logger.
+func (o *ORM) SetRedactArgs(redact bool) {
+	o.hooks.redactArgs = redact
+}
+
+func (h *queryHooks) log(query string, args []interface{}, d time.Duration, rowsAffected int64, err error) {
+	if h.logger == nil {
+		return
+	}
+	slow := h.slowThreshold > 0 && d >= h.slowThreshold
+	if h.slowThreshold > 0 && !slow && err == nil {
+		return
+	}
+	h.logger.LogQuery(&QueryEvent{
+		Query:        query,
+		Args:         h.loggedArgs(args),
+		Duration:     d,
+		RowsAffected: rowsAffected,
+		Err:          err,
+		Slow:         slow,
+	})
+}
+
+// warn sends a warning about query to the query logger, whatever the
+// slow-query threshold.
+func (h *queryHooks) warn(query string, args []interface{}, msg string) {
+	if h == nil || h.logger == nil {
+		return
+	}
+	h.logger.LogQuery(&QueryEvent{Query: query, Args: h.loggedArgs(args), RowsAffected: -1, Warning: msg})
+}
+
+func (h *queryHooks) loggedArgs(args []interface{}) []interface{} {
+	if !h.redactArgs {
+		return args
+	}
+	redacted := make([]interface{}, len(args))
+	for i := range redacted {
+		redacted[i] = redactedArg
+	}
+	return redacted
+}
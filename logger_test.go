@@ -0,0 +1,72 @@
+package orm
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueryLogger(t *testing.T) {
+	var events []*QueryEvent
+	hooks := &queryHooks{logger: QueryLoggerFunc(func(e *QueryEvent) {
+		events = append(events, e)
+	})}
+	tdx := hooks.wrap(nil, (&fakeDB{onExec: func(string, []driver.Value) (driver.Result, error) {
+		return fakeInsertResult{rowsAffected: 3}, nil
+	}}).open())
+	if _, err := tdx.Exec("update t set a = ? where b = ?", 1, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].RowsAffected != 3 || len(events[0].Args) != 2 || events[0].Slow {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	hooks.redactArgs = true
+	tdx.Exec("update t set a = ?", "secret")
+	if events[1].Args[0] != redactedArg {
+		t.Fatalf("args should be redacted, got %v", events[1].Args)
+	}
+}
+
+func TestSlowQueryThreshold(t *testing.T) {
+	var events []*QueryEvent
+	hooks := &queryHooks{
+		logger: QueryLoggerFunc(func(e *QueryEvent) {
+			events = append(events, e)
+		}),
+		slowThreshold: 20 * time.Millisecond,
+	}
+	hooks.wrap(nil, (&fakeDB{}).open()).Exec("select 1")
+	if len(events) != 0 {
+		t.Fatal("fast statement should not be logged")
+	}
+	hooks.wrap(nil, (&fakeDB{onExec: func(string, []driver.Value) (driver.Result, error) {
+		return nil, errors.New("boom")
+	}}).open()).Exec("select 1")
+	if len(events) != 1 || events[0].Err == nil {
+		t.Fatal("failed statement should be logged")
+	}
+	hooks.wrap(nil, (&fakeDB{onExec: func(string, []driver.Value) (driver.Result, error) {
+		time.Sleep(30 * time.Millisecond)
+		return driver.RowsAffected(0), nil
+	}}).open()).Exec("select sleep(1)")
+	if len(events) != 2 || !events[1].Slow {
+		t.Fatal("slow statement should be logged")
+	}
+}
+
+func TestExecWithParamWarning(t *testing.T) {
+	var events []*QueryEvent
+	o := newFakeORM(&fakeDB{})
+	o.SetQueryLogger(QueryLoggerFunc(func(e *QueryEvent) {
+		events = append(events, e)
+	}))
+	o.SetSlowQueryThreshold(time.Hour)
+	if _, err := o.ExecWithParam("delete from user", map[string]interface{}{"id": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Warning == "" || events[0].Query != "delete from user" {
+		t.Fatalf("the warning should reach the query logger, got %+v", events)
+	}
+}
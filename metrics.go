@@ -0,0 +1,307 @@
+package orm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// MetricsCollector receives query, transaction and pool measurements from
+// an ORM. Implementations must be safe for concurrent use.
+type MetricsCollector interface {
+	// ObserveQuery is called once per statement. errClass is empty on success.
+	ObserveQuery(operation, table string, d time.Duration, errClass string)
+	// ObserveTx is called with "commit" or "rollback" and the error class of the outcome.
+	ObserveTx(outcome string, errClass string)
+	ObservePool(stats sql.DBStats)
+}
+
+// poolSource is implemented by collectors that read the pool stats
+// themselves whenever they are scraped.
+type poolSource interface {
+	setPoolSource(stats func() sql.DBStats)
+}
+
+// SetMetrics plugs c into the ORM, nil disables metrics. MemoryMetrics and
+// ExpvarMetrics read the pool stats of the ORM each time they are read, so
+// they need no ReportPoolStats calls.
+func (o *ORM) SetMetrics(c MetricsCollector) {
+	o.hooks.metrics = c
+	if p, ok := c.(poolSource); ok && o.db != nil {
+		p.setPoolSource(o.db.Stats)
+	}
+}
+
+// ReportPoolStats hands the current connection pool stats to the metrics
+// collector. Nothing calls it for you: collectors other than MemoryMetrics
+// and ExpvarMetrics only see fresh pool gauges when it is called
+// periodically, for example from a time.Ticker.
+func (o *ORM) ReportPoolStats() {
+	if o.hooks.metrics != nil {
+		o.hooks.metrics.ObservePool(o.db.Stats())
+	}
+}
+
+func (h *queryHooks) observeQuery(query string, d time.Duration, err error) {
+	if h.metrics == nil {
+		return
+	}
+	h.metrics.ObserveQuery(sqlOperation(query), sqlTableName(query), d, ErrorClass(err))
+}
+
+func (h *queryHooks) observeTx(outcome string, err error) {
+	if h == nil || h.metrics == nil {
+		return
+	}
+	h.metrics.ObserveTx(outcome, ErrorClass(err))
+}
+
+var sqlTableReg = regexp.MustCompile("(?i)\\b(?:from|into|update|table)\\s+`?([a-zA-Z0-9_.]+)`?")
+
+// sqlTableName returns the first table a statement refers to, or "".
+func sqlTableName(query string) string {
+	m := sqlTableReg.FindStringSubmatch(query)
+	if m == nil {
+		return ""
+	}
+	return strings.ToLower(m[1])
+}
+
+// sqlOperation returns the lower cased leading keyword of a statement.
+func sqlOperation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "other"
+	}
+	switch op := strings.ToLower(fields[0]); op {
+	case "select", "insert", "update", "delete", "replace", "show", "truncate", "create", "alter", "drop":
+		return op
+	}
+	return "other"
+}
+
+// ErrorClass buckets err into a small set of labels suitable for metrics,
+// it returns "" for nil.
+func ErrorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+	var myErr *mysql.MySQLError
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return "no_rows"
+	case errors.Is(err, sql.ErrTxDone):
+		return "tx_done"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, driver.ErrBadConn):
+		return "bad_conn"
+	case errors.As(err, &myErr):
+		return "mysql_" + strconv.Itoa(int(myErr.Number))
+	case errors.Is(err, ErrStaleObject):
+		return "stale_object"
+	case IsRowAffectError(err):
+		return "row_affect"
+	}
+	return "other"
+}
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the latency histograms.
+var DefaultLatencyBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram is a snapshot of a cumulative latency histogram. Counts[i]
+// holds the observations <= Buckets[i].
+type Histogram struct {
+	Buckets []float64 `json:"buckets"`
+	Counts  []uint64  `json:"counts"`
+	Count   uint64    `json:"count"`
+	Sum     float64   `json:"sum"`
+}
+
+func (h *Histogram) observe(v float64) {
+	for i, b := range h.Buckets {
+		if v <= b {
+			h.Counts[i]++
+		}
+	}
+	h.Count++
+	h.Sum += v
+}
+
+func (h *Histogram) copy() Histogram {
+	ret := *h
+	ret.Counts = append([]uint64(nil), h.Counts...)
+	return ret
+}
+
+// MemoryMetrics keeps all measurements in memory, it is mainly meant for tests.
+type MemoryMetrics struct {
+	mu      sync.Mutex
+	queries map[string]int64
+	latency map[string]*Histogram
+	errors  map[string]int64
+	tx      map[string]int64
+	pool    sql.DBStats
+	stats   func() sql.DBStats
+}
+
+func NewMemoryMetrics() *MemoryMetrics {
+	return &MemoryMetrics{
+		queries: make(map[string]int64),
+		latency: make(map[string]*Histogram),
+		errors:  make(map[string]int64),
+		tx:      make(map[string]int64),
+	}
+}
+
+func metricsKey(operation, table string) string {
+	return operation + ":" + table
+}
+
+func (m *MemoryMetrics) ObserveQuery(operation, table string, d time.Duration, errClass string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := metricsKey(operation, table)
+	m.queries[key]++
+	h, ok := m.latency[key]
+	if !ok {
+		h = &Histogram{Buckets: DefaultLatencyBuckets, Counts: make([]uint64, len(DefaultLatencyBuckets))}
+		m.latency[key] = h
+	}
+	h.observe(d.Seconds())
+	if errClass != "" {
+		m.errors[errClass]++
+	}
+}
+
+func (m *MemoryMetrics) ObserveTx(outcome string, errClass string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tx[outcome]++
+	if errClass != "" {
+		m.errors[errClass]++
+	}
+}
+
+func (m *MemoryMetrics) ObservePool(stats sql.DBStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pool = stats
+}
+
+func (m *MemoryMetrics) setPoolSource(stats func() sql.DBStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats = stats
+}
+
+// poolLocked returns the live pool stats when a source is set, m.mu must be held.
+func (m *MemoryMetrics) poolLocked() sql.DBStats {
+	if m.stats != nil {
+		return m.stats()
+	}
+	return m.pool
+}
+
+func (m *MemoryMetrics) QueryCount(operation, table string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.queries[metricsKey(operation, table)]
+}
+
+func (m *MemoryMetrics) Latency(operation, table string) Histogram {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.latency[metricsKey(operation, table)]
+	if !ok {
+		return Histogram{}
+	}
+	return h.copy()
+}
+
+func (m *MemoryMetrics) ErrorCount(class string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.errors[class]
+}
+
+func (m *MemoryMetrics) TxCount(outcome string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tx[outcome]
+}
+
+// Pool returns the connection pool stats, read from the database when the
+// metrics are plugged into an ORM and the last reported ones otherwise.
+func (m *MemoryMetrics) Pool() sql.DBStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.poolLocked()
+}
+
+type metricsSnapshot struct {
+	Queries map[string]int64     `json:"queries"`
+	Latency map[string]Histogram `json:"latency_seconds"`
+	Errors  map[string]int64     `json:"errors"`
+	Tx      map[string]int64     `json:"tx"`
+	Pool    sql.DBStats          `json:"pool"`
+}
+
+func (m *MemoryMetrics) snapshot() *metricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ret := &metricsSnapshot{
+		Queries: make(map[string]int64, len(m.queries)),
+		Latency: make(map[string]Histogram, len(m.latency)),
+		Errors:  make(map[string]int64, len(m.errors)),
+		Tx:      make(map[string]int64, len(m.tx)),
+		Pool:    m.poolLocked(),
+	}
+	for k, v := range m.queries {
+		ret.Queries[k] = v
+	}
+	for k, v := range m.latency {
+		ret.Latency[k] = v.copy()
+	}
+	for k, v := range m.errors {
+		ret.Errors[k] = v
+	}
+	for k, v := range m.tx {
+		ret.Tx[k] = v
+	}
+	return ret
+}
+
+// ExpvarMetrics publishes the measurements as one expvar variable.
+type ExpvarMetrics struct {
+	*MemoryMetrics
+}
+
+// NewExpvarMetrics publishes the metrics under name, like expvar.Publish it
+// panics when name is already taken.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	m := &ExpvarMetrics{MemoryMetrics: NewMemoryMetrics()}
+	expvar.Publish(name, m)
+	return m
+}
+
+// String implements expvar.Var.
+func (m *ExpvarMetrics) String() string {
+	b, err := json.Marshal(m.snapshot())
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
@@ -0,0 +1,82 @@
+package orm
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestSqlTableName(t *testing.T) {
+	cases := map[string]string{
+		"select * from test_orm_a123 where test_id = ?": "test_orm_a123",
+		"insert ignore into `user` (a) values(?)":       "user",
+		"UPDATE User SET a = 1":                         "user",
+		"truncate table log":                            "log",
+		"select 1":                                      "",
+	}
+	for q, want := range cases {
+		if got := sqlTableName(q); got != want {
+			t.Errorf("%s: want %q, got %q", q, want, got)
+		}
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	cases := map[error]string{
+		nil:                                    "",
+		sql.ErrNoRows:                          "no_rows",
+		fmt.Errorf("wrap: %w", sql.ErrTxDone):  "tx_done",
+		&mysql.MySQLError{Number: 1062}:        "mysql_1062",
+		errors.New("[RowAffectCheckError]: x"): "row_affect",
+		errors.New("boom"):                     "other",
+	}
+	for err, want := range cases {
+		if got := ErrorClass(err); got != want {
+			t.Errorf("%v: want %q, got %q", err, want, got)
+		}
+	}
+}
+
+func TestMemoryMetrics(t *testing.T) {
+	m := NewMemoryMetrics()
+	hooks := &queryHooks{metrics: m}
+	tdx := hooks.wrap(nil, (&fakeDB{}).open())
+	tdx.Exec("insert into user (name) values(?)", "a")
+	tdx.Exec("insert into user (name) values(?)", "b")
+	hooks.wrap(nil, (&fakeDB{onQuery: func(string, []driver.Value) (*fakeRows, error) {
+		return nil, &mysql.MySQLError{Number: 1146}
+	}}).open()).Query("select * from missing")
+	hooks.observeTx("commit", nil)
+
+	if m.QueryCount("insert", "user") != 2 || m.QueryCount("select", "missing") != 1 {
+		t.Fatal("incorrect query counts")
+	}
+	if h := m.Latency("insert", "user"); h.Count != 2 || h.Counts[len(h.Counts)-1] != 2 {
+		t.Fatalf("incorrect latency histogram %+v", h)
+	}
+	if m.ErrorCount("mysql_1146") != 1 || m.TxCount("commit") != 1 {
+		t.Fatal("incorrect error or tx counts")
+	}
+}
+
+func TestMetricsPoolStatsAreLive(t *testing.T) {
+	o := newFakeORM(&fakeDB{})
+	m := NewMemoryMetrics()
+	o.SetMetrics(m)
+	if m.Pool().OpenConnections != 0 {
+		t.Fatalf("want no open connections, got %+v", m.Pool())
+	}
+	if _, err := o.db.Exec("insert into user (name) values(?)", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if m.Pool().OpenConnections != 1 {
+		t.Fatalf("want the pool stats read at scrape time, got %+v", m.Pool())
+	}
+	if s := m.snapshot(); s.Pool.OpenConnections != 1 {
+		t.Fatalf("want the snapshot to read the pool, got %+v", s.Pool)
+	}
+}
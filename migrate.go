@@ -0,0 +1,446 @@
+package orm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migration is a versioned schema change, written either as Go functions
+// or as SQL text. Versions are applied in ascending order.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(db ORMer) error
+	Down    func(db ORMer) error
+	UpSQL   string
+	DownSQL string
+	// NoTx runs the migration outside a transaction even when the dialect
+	// could roll its DDL back.
+	NoTx bool
+}
+
+// MigrationStatus is a migration and whether it was applied.
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Unknown is an applied version the Migrator has no migration for.
+	Unknown bool
+}
+
+// Migrator applies and rolls back migrations, recording the applied ones
+// in a history table. A named lock keeps two processes from migrating at
+// the same time.
+//
+// MySQL commits DDL implicitly, so MySQLDialect runs migrations outside a
+// transaction: a migration failing half way is recorded as not applied
+// and has to be repaired by hand.
+type Migrator struct {
+	// Table is the history table, schema_migrations by default.
+	Table       string
+	LockName    string
+	LockTimeout time.Duration
+	// DryRun reports what Up and Down would do without changing anything,
+	// writing the SQL to Out when it is set.
+	DryRun bool
+	Out    io.Writer
+
+	o          *ORM
+	migrations []*Migration
+}
+
+// NewMigrator returns a Migrator running on o.
+func NewMigrator(o *ORM) *Migrator {
+	return &Migrator{
+		Table:       "schema_migrations",
+		LockName:    "schema_migrations",
+		LockTimeout: time.Minute,
+		o:           o,
+	}
+}
+
+// Add registers migrations.
+func (m *Migrator) Add(migrations ...*Migration) *Migrator {
+	m.migrations = append(m.migrations, migrations...)
+	return m
+}
+
+// LoadFS registers the SQL migrations of dir in fsys, usually an embed.FS.
+// Files are named <version>_<name>.up.sql and <version>_<name>.down.sql.
+func (m *Migrator) LoadFS(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		file := e.Name()
+		if e.IsDir() || !strings.HasSuffix(file, ".sql") {
+			continue
+		}
+		base := strings.TrimSuffix(file, ".sql")
+		up := strings.HasSuffix(base, ".up")
+		if !up && !strings.HasSuffix(base, ".down") {
+			return errors.New("migration " + file + " is neither .up.sql nor .down.sql")
+		}
+		base = base[:strings.LastIndexByte(base, '.')]
+		prefix, name, _ := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return errors.New("migration " + file + " does not start with a version")
+		}
+		b, err := fs.ReadFile(fsys, path.Join(dir, file))
+		if err != nil {
+			return err
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: name}
+			byVersion[version] = mig
+			m.migrations = append(m.migrations, mig)
+		}
+		if up {
+			mig.UpSQL = string(b)
+		} else {
+			mig.DownSQL = string(b)
+		}
+	}
+	return nil
+}
+
+// sorted returns the migrations by version, rejecting duplicates.
+func (m *Migrator) sorted() ([]*Migration, error) {
+	ret := append([]*Migration(nil), m.migrations...)
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Version < ret[j].Version })
+	for i := 1; i < len(ret); i++ {
+		if ret[i].Version == ret[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", ret[i].Version)
+		}
+	}
+	return ret, nil
+}
+
+func (m *Migrator) ctx() context.Context {
+	if m.o.ctx != nil {
+		return m.o.ctx
+	}
+	return context.Background()
+}
+
+// lock takes the migration lock and returns the function releasing it.
+func (m *Migrator) lock() (func(), error) {
+	if m.DryRun {
+		return func() {}, nil
+	}
+	ctx := m.ctx()
+	conn, err := m.o.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d := m.o.hooks.getDialect()
+	if err := d.AcquireLock(ctx, conn, m.LockName, m.LockTimeout); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return func() {
+		d.ReleaseLock(ctx, conn, m.LockName)
+		conn.Close()
+	}, nil
+}
+
+// schemaMigration is a row of the history table.
+type schemaMigration struct {
+	Version   int64  `pk:"true"`
+	Name      string `size:"255"`
+	AppliedAt time.Time
+}
+
+func (m *Migrator) createTable() error {
+	def, err := tableDef(schemaMigration{})
+	if err != nil {
+		return err
+	}
+	def.Name = m.Table
+	stmts, err := m.o.hooks.getDialect().CreateTable(def)
+	if err != nil {
+		return err
+	}
+	for _, q := range stmts {
+		if _, err := m.o.Exec(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applied reads the history table, which is created unless in a dry run.
+func (m *Migrator) applied() ([]*schemaMigration, error) {
+	exists, err := tableExists(m.o.tdx(), m.Table)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if m.DryRun {
+			return nil, nil
+		}
+		return nil, m.createTable()
+	}
+	_, rows, err := m.o.SelectValues("select version, name, applied_at from " + m.Table + " order by version")
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*schemaMigration, 0, len(rows))
+	for _, row := range rows {
+		r := &schemaMigration{}
+		switch v := row[0].(type) {
+		case int64:
+			r.Version = v
+		case uint64:
+			r.Version = int64(v)
+		default:
+			return nil, fmt.Errorf("unexpected version %v in %s", v, m.Table)
+		}
+		r.Name, _ = row[1].(string)
+		r.AppliedAt, _ = row[2].(time.Time)
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+// Status lists every migration, known or applied, by version.
+func (m *Migrator) Status() ([]*MigrationStatus, error) {
+	migrations, err := m.sorted()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*MigrationStatus{}
+	ret := make([]*MigrationStatus, 0, len(migrations))
+	for _, mig := range migrations {
+		st := &MigrationStatus{Version: mig.Version, Name: mig.Name}
+		byVersion[mig.Version] = st
+		ret = append(ret, st)
+	}
+	for _, a := range applied {
+		st, ok := byVersion[a.Version]
+		if !ok {
+			st = &MigrationStatus{Version: a.Version, Name: a.Name, Unknown: true}
+			ret = append(ret, st)
+		}
+		st.Applied, st.AppliedAt = true, a.AppliedAt
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Version < ret[j].Version })
+	return ret, nil
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up() ([]*Migration, error) {
+	return m.UpTo(0)
+}
+
+// UpTo applies the pending migrations up to version, 0 means all. It
+// returns the migrations applied, or those it would apply in a dry run.
+func (m *Migrator) UpTo(version int64) ([]*Migration, error) {
+	migrations, err := m.sorted()
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := m.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[int64]bool, len(applied))
+	for _, a := range applied {
+		done[a.Version] = true
+	}
+	ret := []*Migration{}
+	for _, mig := range migrations {
+		if done[mig.Version] || (version > 0 && mig.Version > version) {
+			continue
+		}
+		if err := m.apply(mig, true); err != nil {
+			return ret, fmt.Errorf("migration %d %s: %v", mig.Version, mig.Name, err)
+		}
+		ret = append(ret, mig)
+	}
+	return ret, nil
+}
+
+// Down rolls back the last steps applied migrations, newest first.
+func (m *Migrator) Down(steps int) ([]*Migration, error) {
+	migrations, err := m.sorted()
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := m.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration, len(migrations))
+	for _, mig := range migrations {
+		byVersion[mig.Version] = mig
+	}
+	ret := []*Migration{}
+	for i := len(applied) - 1; i >= 0 && len(ret) < steps; i-- {
+		mig, ok := byVersion[applied[i].Version]
+		if !ok {
+			return ret, fmt.Errorf("no migration for applied version %d", applied[i].Version)
+		}
+		if err := m.apply(mig, false); err != nil {
+			return ret, fmt.Errorf("rollback %d %s: %v", mig.Version, mig.Name, err)
+		}
+		ret = append(ret, mig)
+	}
+	return ret, nil
+}
+
+// apply runs mig up or down and records it in the history table.
+func (m *Migrator) apply(mig *Migration, up bool) error {
+	fn, text := mig.Up, mig.UpSQL
+	if !up {
+		fn, text = mig.Down, mig.DownSQL
+	}
+	if fn == nil && text == "" {
+		if up {
+			return errors.New("no up migration")
+		}
+		return errors.New("no down migration")
+	}
+	if m.DryRun {
+		if m.Out != nil {
+			m.printPlan(mig, up, fn != nil, text)
+		}
+		return nil
+	}
+	run := func(db ORMer) error {
+		if fn != nil {
+			if err := fn(db); err != nil {
+				return err
+			}
+		} else {
+			for _, q := range splitStatements(text) {
+				if _, err := db.Exec(q); err != nil {
+					return err
+				}
+			}
+		}
+		if up {
+			_, err := db.Exec("insert into "+m.Table+" (version, name, applied_at) values (?, ?, ?)", mig.Version, mig.Name, m.o.hooks.now())
+			return err
+		}
+		_, err := db.Exec("delete from "+m.Table+" where version = ?", mig.Version)
+		return err
+	}
+	if mig.NoTx || !m.o.hooks.getDialect().TransactionalDDL() {
+		return run(m.o)
+	}
+	tx, err := m.o.Begin()
+	if err != nil {
+		return err
+	}
+	if err := run(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *Migrator) printPlan(mig *Migration, up bool, isFunc bool, text string) {
+	dir := "up"
+	if !up {
+		dir = "down"
+	}
+	fmt.Fprintf(m.Out, "-- %s %d %s\n", dir, mig.Version, mig.Name)
+	if isFunc {
+		fmt.Fprintln(m.Out, "-- (Go function)")
+		return
+	}
+	for _, q := range splitStatements(text) {
+		fmt.Fprintf(m.Out, "%s;\n", q)
+	}
+}
+
+// splitStatements cuts a SQL script into statements at the semicolons
+// outside quotes, dropping comments. Like the mysql client, a DELIMITER
+// line changes the delimiter, so that trigger and procedure bodies keep
+// theirs:
+//
+//	DELIMITER //
+//	CREATE TRIGGER t BEFORE INSERT ON user FOR EACH ROW BEGIN SET NEW.age = 0; END//
+//	DELIMITER ;
+func splitStatements(script string) []string {
+	ret := []string{}
+	delim := ";"
+	var buf strings.Builder
+	flush := func() {
+		if q := strings.TrimSpace(buf.String()); q != "" {
+			ret = append(ret, q)
+		}
+		buf.Reset()
+	}
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		if (i == 0 || script[i-1] == '\n') && strings.TrimSpace(buf.String()) == "" {
+			line := script[i:]
+			if end := strings.IndexByte(line, '\n'); end >= 0 {
+				line = line[:end]
+			}
+			if f := strings.Fields(line); len(f) == 2 && strings.EqualFold(f[0], "delimiter") {
+				delim = f[1]
+				buf.Reset()
+				i += len(line)
+				continue
+			}
+		}
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := quoteEnd(script, i)
+			buf.WriteString(script[i:end])
+			i = end - 1
+		case c == '#' || c == '-' && strings.HasPrefix(script[i:], "-- "):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end
+				buf.WriteByte('\n')
+			}
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 3
+			}
+		case strings.HasPrefix(script[i:], delim):
+			flush()
+			i += len(delim) - 1
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	flush()
+	return ret
+}
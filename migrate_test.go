@@ -0,0 +1,160 @@
+package orm
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// migrationDB fakes a database keeping a schema_migrations table.
+func migrationDB() (*fakeDB, *[]int64) {
+	var created bool
+	versions := &[]int64{}
+	fdb := &fakeDB{}
+	fdb.onQuery = func(query string, args []driver.Value) (*fakeRows, error) {
+		switch {
+		case strings.HasPrefix(query, "select get_lock"):
+			return &fakeRows{cols: []string{"ok"}, rows: [][]driver.Value{{int64(1)}}}, nil
+		case strings.HasPrefix(query, "show tables"):
+			if !created {
+				return nil, nil
+			}
+			return &fakeRows{cols: []string{"t"}, rows: [][]driver.Value{{"schema_migrations"}}}, nil
+		case strings.HasPrefix(query, "select version"):
+			ret := &fakeRows{cols: []string{"version", "name", "applied_at"}}
+			for _, v := range *versions {
+				ret.rows = append(ret.rows, []driver.Value{v, "m", time.Now()})
+			}
+			return ret, nil
+		}
+		return nil, nil
+	}
+	fdb.onExec = func(query string, args []driver.Value) (driver.Result, error) {
+		switch {
+		case strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS `schema_migrations`"):
+			created = true
+		case strings.HasPrefix(query, "insert into schema_migrations"):
+			*versions = append(*versions, args[0].(int64))
+		case strings.HasPrefix(query, "delete from schema_migrations"):
+			*versions = (*versions)[:len(*versions)-1]
+		case strings.Contains(query, "boom"):
+			return nil, errors.New("boom")
+		}
+		return driver.RowsAffected(1), nil
+	}
+	return fdb, versions
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	fdb, versions := migrationDB()
+	m := NewMigrator(newFakeORM(fdb))
+	err := m.LoadFS(fstest.MapFS{
+		"migrations/2_add_age.up.sql":     {Data: []byte("alter table user add age int; -- the age\nalter table user add note varchar(8) default 'a;b';")},
+		"migrations/2_add_age.down.sql":   {Data: []byte("alter table user drop age; alter table user drop note;")},
+		"migrations/1_create_user.up.sql": {Data: []byte("create table user (id int)")},
+	}, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	goRan := false
+	m.Add(&Migration{Version: 3, Name: "backfill", Up: func(db ORMer) error {
+		goRan = true
+		_, err := db.Exec("update user set age = 1")
+		return err
+	}})
+
+	buf := &bytes.Buffer{}
+	m.DryRun, m.Out = true, buf
+	planned, err := m.Up()
+	if err != nil || len(planned) != 3 || len(fdb.execs) != 0 {
+		t.Fatalf("dry run should only plan, got %v %v %v", planned, fdb.execQueries(), err)
+	}
+	if !strings.Contains(buf.String(), "alter table user add note varchar(8) default 'a;b';\n") {
+		t.Fatalf("unexpected plan %s", buf.String())
+	}
+
+	m.DryRun = false
+	applied, err := m.UpTo(2)
+	if err != nil || len(applied) != 2 || !reflect.DeepEqual(*versions, []int64{1, 2}) {
+		t.Fatalf("unexpected up result %v %v %v", applied, *versions, err)
+	}
+	if _, err := m.Up(); err != nil || !goRan || len(*versions) != 3 {
+		t.Fatalf("unexpected up result %v %v", *versions, err)
+	}
+
+	st, err := m.Status()
+	if err != nil || len(st) != 3 || !st[2].Applied {
+		t.Fatalf("unexpected status %v %v", st, err)
+	}
+
+	// the Go migration has no down
+	if _, err := m.Down(1); err == nil {
+		t.Fatal("rollback without down should fail")
+	}
+	*versions = []int64{1, 2}
+	if rolled, err := m.Down(1); err != nil || len(rolled) != 1 || rolled[0].Version != 2 || len(*versions) != 1 {
+		t.Fatalf("unexpected down result %v %v", rolled, err)
+	}
+}
+
+func TestMigratorFailure(t *testing.T) {
+	fdb, versions := migrationDB()
+	m := NewMigrator(newFakeORM(fdb))
+	m.Add(&Migration{Version: 1, UpSQL: "select boom"}, &Migration{Version: 2, UpSQL: "select 1"})
+	if _, err := m.Up(); err == nil || len(*versions) != 0 {
+		t.Fatalf("failed migration should stop the run, got %v %v", *versions, err)
+	}
+	m.Add(&Migration{Version: 2})
+	if _, err := m.Up(); err == nil {
+		t.Fatal("duplicate versions should be rejected")
+	}
+
+	m = NewMigrator(newFakeORM(fdb))
+	if err := m.LoadFS(fstest.MapFS{"m/1_user.down.sql": {Data: []byte("drop table user")}}, "m"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(); err == nil || !strings.Contains(err.Error(), "no up migration") || len(*versions) != 0 {
+		t.Fatalf("a migration without up should not be recorded, got %v %v", *versions, err)
+	}
+}
+
+func TestMigratorClock(t *testing.T) {
+	fdb, _ := migrationDB()
+	o := newFakeORM(fdb)
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	o.SetClock(func() time.Time { return now })
+	m := NewMigrator(o)
+	m.Add(&Migration{Version: 1, UpSQL: "select 1"})
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	recorded := 0
+	for _, e := range fdb.execs {
+		if strings.HasPrefix(e.query, "insert into schema_migrations") {
+			if e.args[2] != now {
+				t.Fatalf("applied_at should come from the clock, got %v", e.args[2])
+			}
+			recorded++
+		}
+	}
+	if recorded != 1 {
+		t.Fatalf("the migration should be recorded once, got %v", fdb.execQueries())
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	got := splitStatements("a 'x;y'; /* c; */ b # d;\n;\"e;\"")
+	if !reflect.DeepEqual(got, []string{"a 'x;y'", "b", "\"e;\""}) {
+		t.Fatalf("unexpected statements %q", got)
+	}
+	got = splitStatements("DELIMITER //\nCREATE TRIGGER t BEFORE INSERT ON user FOR EACH ROW BEGIN SET NEW.age = 0; SET NEW.note = ''; END//\nDELIMITER ;\nselect 1; select 2;")
+	want := []string{"CREATE TRIGGER t BEFORE INSERT ON user FOR EACH ROW BEGIN SET NEW.age = 0; SET NEW.note = ''; END", "select 1", "select 2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected statements with delimiter %q", got)
+	}
+}
@@ -5,25 +5,21 @@ package orm
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"reflect"
-	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 	"unicode"
 
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/xlvector/dlog"
 )
 
-var sqlParamReg *regexp.Regexp
-var initOnce sync.Once
-
 func colName2FieldName(buf string) string {
 	tks := strings.Split(buf, "_")
 	ret := ""
@@ -48,6 +44,31 @@ func fieldName2ColName(buf string) string {
 	return w.String()
 }
 
+// columnName returns the column of field f: its db tag, or its name in snake_case.
+func columnName(f reflect.StructField) string {
+	if col := f.Tag.Get("db"); col != "" {
+		return col
+	}
+	if f.Anonymous && f.Type == softDeleteType {
+		return "deleted_at"
+	}
+	return fieldName2ColName(f.Name)
+}
+
+// fieldByColumn returns the field of t holding the column col.
+func fieldByColumn(t reflect.Type, col string) (reflect.StructField, bool) {
+	for k := 0; k < t.NumField(); k++ {
+		if t.Field(k).Tag.Get("db") == col {
+			return t.Field(k), true
+		}
+	}
+	f, ok := t.FieldByName(colName2FieldName(col))
+	if ok && f.Tag.Get("db") != "" && f.Tag.Get("db") != col {
+		return f, false
+	}
+	return f, ok
+}
+
 func reflectStruct(s interface{}, cols []string, row *sql.Rows) error {
 	v := reflect.ValueOf(s)
 	return reflectStructValue(v, cols, row)
@@ -57,39 +78,41 @@ func reflectStructValue(v reflect.Value, cols []string, row *sql.Rows) error {
 	if v.Kind() != reflect.Ptr {
 		panic(errors.New("holder should be pointer"))
 	}
-	v = v.Elem()
-	targets := make([]interface{}, len(cols))
+	return newScanPlan(v.Type().Elem(), cols).scan(row, v)
+}
+
+// scanPlan maps the columns of a result set to struct fields once, so that
+// every row only has to collect the scan targets.
+type scanPlan struct {
+	fields [][]int
+}
+
+func newScanPlan(t reflect.Type, cols []string) *scanPlan {
+	p := &scanPlan{fields: make([][]int, len(cols))}
 	for k, c := range cols {
-		fv := v.FieldByName(colName2FieldName(c))
-		if !fv.CanAddr() {
-			//log.Println("missing filed", c)
+		if f, ok := fieldByColumn(t, c); ok {
+			p.fields[k] = f.Index
+		}
+	}
+	return p
+}
+
+// scan reads the current row into v, a pointer to the struct the plan was built for.
+func (p *scanPlan) scan(row *sql.Rows, v reflect.Value) error {
+	v = v.Elem()
+	targets := make([]interface{}, len(p.fields))
+	for k, idx := range p.fields {
+		if idx == nil {
 			var b interface{}
 			targets[k] = &b
 		} else {
-			targets[k] = fv.Addr().Interface()
+			targets[k] = v.FieldByIndex(idx).Addr().Interface()
 		}
 	}
-	err := row.Scan(targets...)
-	if err != nil {
+	if err := row.Scan(targets...); err != nil {
 		return err
 	}
-	return nil
-}
-
-func checkStruct(s interface{}, cols []string, tableName string) error {
-
-	v := reflect.TypeOf(s)
-
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
-	}
-	for _, c := range cols {
-		_, ok := v.FieldByName(colName2FieldName(c))
-		if !ok {
-			return errors.New(tableName + " missing field " + c)
-		}
-	}
-	return nil
+	return afterFind(v.Addr())
 }
 
 type Tdx interface {
@@ -97,8 +120,18 @@ type Tdx interface {
 	Query(string, ...interface{}) (*sql.Rows, error)
 }
 
-func getColumns(tdx Tdx, tableName string) ([]string, error) {
-	ret := []string{}
+// ColumnInfo is a column as described by show columns.
+type ColumnInfo struct {
+	Name     string
+	Type     string
+	Nullable bool
+	Key      string
+	Default  sql.NullString
+	Extra    string
+}
+
+func getColumnInfos(tdx Tdx, tableName string) ([]*ColumnInfo, error) {
+	ret := []*ColumnInfo{}
 	rows, err := tdx.Query("show columns from " + tableName)
 	if err != nil {
 		return ret, err
@@ -109,7 +142,14 @@ func getColumns(tdx Tdx, tableName string) ([]string, error) {
 		if err := rows.Scan(&name, &tp, &nu, &key, &dft, &extra); err != nil {
 			return ret, errors.New("can not scan filed:" + err.Error())
 		}
-		ret = append(ret, name.String)
+		ret = append(ret, &ColumnInfo{
+			Name:     name.String,
+			Type:     tp.String,
+			Nullable: nu.String == "YES",
+			Key:      key.String,
+			Default:  dft,
+			Extra:    extra.String,
+		})
 	}
 	if err := rows.Err(); err != nil {
 		return ret, err
@@ -117,53 +157,50 @@ func getColumns(tdx Tdx, tableName string) ([]string, error) {
 	return ret, nil
 }
 
-func checkTableColumns(tdx Tdx, s interface{}) error {
-	tableName := getTableName(s)
-	cols, err := getColumns(tdx, tableName)
-	if err != nil {
-		return err
+func getColumns(tdx Tdx, tableName string) ([]string, error) {
+	infos, err := getColumnInfos(tdx, tableName)
+	ret := make([]string, len(infos))
+	for k, c := range infos {
+		ret[k] = c.Name
 	}
-	log.Println(tableName, cols)
-	return checkStruct(s, cols, tableName)
+	return ret, err
 }
 
 func exec(tdx Tdx, query string, args ...interface{}) (sql.Result, error) {
 	return tdx.Exec(query, args...)
 }
 
-func execWithParam(tdx Tdx, paramQuery string, paramMap interface{}) (sql.Result, error) {
-	params := sqlParamReg.FindAllString(paramQuery, -1)
-	if params != nil && len(params) > 0 {
-		var args []interface{} = make([]interface{}, 0, len(params))
-		for _, param := range params {
-			param = param[2 : len(param)-1]
-			value, err := getFieldValue(paramMap, param)
-			if err != nil {
-				return nil, err
-			}
-			args = append(args, value)
-		}
-		paramQuery = sqlParamReg.ReplaceAllLiteralString(paramQuery, "?")
-		return tdx.Exec(paramQuery, args...)
-	} else {
-		dlog.Warn("no parameter found in paramQuery string")
-		return tdx.Exec(paramQuery)
+func execWithParam(tdx Tdx, h *queryHooks, paramQuery string, paramMap interface{}) (sql.Result, error) {
+	query, args, err := expandParams(paramQuery, paramMap)
+	if err != nil {
+		return nil, err
 	}
+	if len(args) == 0 {
+		h.warn(paramQuery, args, "no parameter found in paramQuery string")
+	}
+	return tdx.Exec(query, args...)
 }
 
 func execWithRowAffectCheck(tdx Tdx, expectRows int64, query string, args ...interface{}) error {
+	_, err := execRowAffectCheck(tdx, expectRows, query, args...)
+	return err
+}
+
+// execRowAffectCheck works like execWithRowAffectCheck and returns the rows
+// really affected, 0 when the statement failed.
+func execRowAffectCheck(tdx Tdx, expectRows int64, query string, args ...interface{}) (int64, error) {
 	ret, err := tdx.Exec(query, args...)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	ra, err := ret.RowsAffected()
 	if err != nil {
-		return err
+		return 0, err
 	}
 	if ra != expectRows {
-		return errors.New(fmt.Sprintf("[RowAffectCheckError]: query [%s] should only affect %d rows, really affect %d rows", query, expectRows, ra))
+		return ra, errors.New(fmt.Sprintf("[RowAffectCheckError]: query [%s] should only affect %d rows, really affect %d rows", query, expectRows, ra))
 	}
-	return nil
+	return ra, nil
 }
 
 func getPKColumn(s interface{}) string {
@@ -175,7 +212,7 @@ func getPkColumnByType(t reflect.Type) string {
 	for k := 0; k < t.NumField(); k++ {
 		ft := t.Field(k)
 		if ft.Tag.Get("pk") == "true" {
-			return fieldName2ColName(ft.Name)
+			return columnName(ft)
 		}
 	}
 	return ""
@@ -254,11 +291,11 @@ func getTableName(s interface{}) string {
 
 func selectByPK(tdx Tdx, s interface{}, pk interface{}) error {
 	pkname := getPKColumn(s)
-	tabname := getTableName(s)
+	tabname := GetMapTable(getTableName(s))
 	if pkname == "" {
 		return errors.New(tabname + " does not have primary key")
 	}
-	return selectOne(tdx, s, fmt.Sprintf("select * from %s where %s = ?", tabname, pkname), pk)
+	return selectOne(tdx, s, fmt.Sprintf("select * from %s where %s = ?%s", tabname, pkname, notDeleted(tdx, reflect.TypeOf(s))), pk)
 }
 
 func selectOne(tdx Tdx, s interface{}, query string, args ...interface{}) error {
@@ -283,7 +320,7 @@ func selectOne(tdx Tdx, s interface{}, query string, args ...interface{}) error
 			} else if orCol.or == "has_many" {
 				orField := v.FieldByName(orCol.fieldName)
 				err = selectManyInternal(tdx, orField.Addr().Interface(), false,
-					"SELECT * FROM "+orCol.table+" WHERE "+fieldName2ColName(pk.Name)+" = ?", pkValue)
+					"SELECT * FROM "+orCol.table+" WHERE "+columnName(pk)+" = ?"+notDeleted(tdx, orCol.orType), pkValue)
 				if err != nil {
 					return err
 				}
@@ -329,7 +366,7 @@ func selectOneInternal(tdx Tdx, s interface{}, query string, args ...interface{}
 }
 
 func processOrHasOneRelation(tdx Tdx, orCol *orColumn, v reflect.Value, pk reflect.StructField, pkValue interface{}) error {
-	orRows, err := tdx.Query("SELECT * FROM "+orCol.table+" WHERE "+fieldName2ColName(pk.Name)+" = ? LIMIT 1",
+	orRows, err := tdx.Query("SELECT * FROM "+orCol.table+" WHERE "+columnName(pk)+" = ?"+notDeleted(tdx, orCol.orType)+" LIMIT 1",
 		pkValue)
 	if err != nil {
 		return err
@@ -354,7 +391,7 @@ func processOrHasOneRelation(tdx Tdx, orCol *orColumn, v reflect.Value, pk refle
 }
 
 func processOrBelongsToRelation(tdx Tdx, orCol *orColumn, v reflect.Value, fk string, fkValue interface{}) error {
-	orRows, err := tdx.Query("SELECT * FROM "+orCol.table+" WHERE "+fk+" = ? LIMIT 1",
+	orRows, err := tdx.Query("SELECT * FROM "+orCol.table+" WHERE "+fk+" = ?"+notDeleted(tdx, orCol.orType)+" LIMIT 1",
 		fkValue)
 	if err != nil {
 		return err
@@ -423,7 +460,7 @@ func toSliceType(i interface{}) (reflect.Type, error) {
 	return t.Elem(), nil
 }
 
-func selectRawSet(tdx Tdx, query string, args ...interface{}) ([]map[string]string, error) {
+func selectRawSet(tdx Tdx, format *RawFormat, query string, args ...interface{}) ([]map[string]string, error) {
 	rows, err := tdx.Query(query, args...)
 	if err != nil {
 		return nil, err
@@ -446,34 +483,19 @@ func selectRawSet(tdx Tdx, query string, args ...interface{}) ([]map[string]stri
 		err = rows.Scan(itemList...)
 
 		if err != nil {
-			log.Println("%v, %v", err, rows)
 			return dataSet, err
 		}
 		for k, c := range cols {
-			fname := colName2FieldName(c)
-			switch t := (*itemList[k].(*interface{})).(type) {
-			case []uint8:
-				itemMap[fname] = string(t[:])
-			case time.Time:
-				itemMap[fname] = t.Format("2006-01-02 15:04:05")
-			case int64:
-				itemMap[fname] = strconv.FormatInt(t, 10)
-			case int:
-				itemMap[fname] = strconv.Itoa(t)
-			case float32:
-				itemMap[fname] = strconv.FormatFloat(float64(t), 'f', 4, 32)
-			case float64:
-				itemMap[fname] = strconv.FormatFloat(t, 'f', 4, 64)
-			case nil:
-			default:
+			if str, ok := format.format(*itemList[k].(*interface{})); ok {
+				itemMap[colName2FieldName(c)] = str
 			}
 		}
 		dataSet = append(dataSet, itemMap)
 	}
-	return dataSet, nil
+	return dataSet, rows.Err()
 }
 
-func selectRaw(tdx Tdx, query string, args ...interface{}) ([]string, [][]string, error) {
+func selectRaw(tdx Tdx, format *RawFormat, query string, args ...interface{}) ([]string, [][]string, error) {
 	rows, err := tdx.Query(query, args...)
 	if err != nil {
 		return nil, nil, err
@@ -496,30 +518,14 @@ func selectRaw(tdx Tdx, query string, args ...interface{}) ([]string, [][]string
 		err = rows.Scan(itemList...)
 
 		if err != nil {
-			log.Println("%v, %v", err, rows)
 			return colNames, data, err
 		}
 		for k, _ := range colNames {
-			switch t := (*itemList[k].(*interface{})).(type) {
-			case []uint8:
-				itemMap[k] = string(t[:])
-			case time.Time:
-				itemMap[k] = t.Format("2006-01-02 15:04:05")
-			case int64:
-				itemMap[k] = strconv.FormatInt(t, 10)
-			case int:
-				itemMap[k] = strconv.Itoa(t)
-			case float32:
-				itemMap[k] = strconv.FormatFloat(float64(t), 'f', 4, 32)
-			case float64:
-				itemMap[k] = strconv.FormatFloat(t, 'f', 4, 64)
-			case nil:
-			default:
-			}
+			itemMap[k], _ = format.format(*itemList[k].(*interface{}))
 		}
 		data = append(data, itemMap)
 	}
-	return colNames, data, nil
+	return colNames, data, rows.Err()
 }
 
 func selectMany(tdx Tdx, s interface{}, query string, args ...interface{}) error {
@@ -531,24 +537,29 @@ func selectManyInternal(tdx Tdx, s interface{}, processOr bool, query string, ar
 	if err != nil {
 		return err
 	}
+	if t == nil {
+		return errors.New("can not select into a non-slice " + reflect.TypeOf(s).String())
+	}
 
-	if t.Kind() != reflect.Ptr && t.Kind() != reflect.Int64 && t.Kind() != reflect.String &&
-		t.Kind() != reflect.Int && t.Kind() != reflect.Bool && t.Kind() != reflect.Float64 &&
-		t.Kind() != reflect.Float32 && t.Kind() != reflect.Uint64 && t.Kind() != reflect.Uint {
+	if t.Kind() == reflect.Interface || t.Kind() == reflect.Func || t.Kind() == reflect.Chan ||
+		t.Kind() == reflect.Map || t.Kind() == reflect.UnsafePointer {
 		return errors.New("slice elements type " + t.Kind().String() + " not supported")
 	}
 
-	var isPtr = (t.Kind() == reflect.Ptr)
+	// model structs are scanned field by field, through a pointer ([]*User)
+	// or by value ([]User), anything else goes to database/sql as a whole
+	var isPtr = t.Kind() == reflect.Ptr && isModelType(t.Elem())
+	var isStruct = isModelType(t)
 
 	hasOrCols := false
 	pkCol := reflect.StructField{}
 	var orCols []*orColumn = nil
 	if isPtr {
 		t = t.Elem()
-		if processOr {
-			pkCol, orCols = getOrColumnsByType(t)
-			hasOrCols = orCols != nil && len(orCols) > 0
-		}
+	}
+	if (isPtr || isStruct) && processOr {
+		pkCol, orCols = getOrColumnsByType(t)
+		hasOrCols = orCols != nil && len(orCols) > 0
 	}
 
 	sliceValue := reflect.Indirect(reflect.ValueOf(s))
@@ -559,42 +570,43 @@ func selectManyInternal(tdx Tdx, s interface{}, processOr bool, query string, ar
 	}
 	defer rows.Close()
 
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	var plan *scanPlan
+	if isPtr || isStruct {
+		plan = newScanPlan(t, cols)
+	}
+
 	keys := make([]interface{}, 0)
 	resMap := map[interface{}]reflect.Value{}
-	for rows.Next() {
-		cols, err := rows.Columns()
-		if err != nil {
-			return err
+	track := func(v reflect.Value) {
+		pkFv := v.Elem().FieldByName(pkCol.Name)
+		if pkFv.IsValid() {
+			key := pkFv.Interface()
+			keys = append(keys, key)
+			resMap[key] = v
 		}
+	}
+	start := sliceValue.Len()
+	for rows.Next() {
 		v := reflect.New(t)
 		if isPtr {
-			targets := make([]interface{}, len(cols))
-			for k, c := range cols {
-				fname := colName2FieldName(c)
-				fv := v.Elem().FieldByName(fname)
-				if !fv.CanAddr() {
-					//fmt.Printf("missing field: %s , query: %s", fname, query)
-					var b interface{}
-					targets[k] = &b
-					continue
-				}
-				targets[k] = fv.Addr().Interface()
-			}
-			err = rows.Scan(targets...)
-
+			err = plan.scan(rows, v)
 			if err != nil {
-				log.Println("%v, %v", err, rows)
 				return err
 			}
 			sliceValue.Set(reflect.Append(sliceValue, v))
 			if hasOrCols {
-				pkFv := v.Elem().FieldByName(pkCol.Name)
-				if pkFv.IsValid() {
-					key := pkFv.Interface()
-					keys = append(keys, key)
-					resMap[key] = v
-				}
+				track(v)
 			}
+		} else if isStruct {
+			err = plan.scan(rows, v)
+			if err != nil {
+				return err
+			}
+			sliceValue.Set(reflect.Append(sliceValue, v.Elem()))
 		} else {
 			err = rows.Scan(v.Interface())
 			if err != nil {
@@ -603,90 +615,234 @@ func selectManyInternal(tdx Tdx, s interface{}, processOr bool, query string, ar
 			sliceValue.Set(reflect.Append(sliceValue, v.Elem()))
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if isStruct && hasOrCols {
+		// the slice may have grown while scanning, take the addresses once it is complete
+		for i := start; i < sliceValue.Len(); i++ {
+			track(sliceValue.Index(i).Addr())
+		}
+	}
 	if len(keys) > 0 {
-		for _, orCol := range orCols {
-			var sqlQuery string
-			// 如果是belongs_to，需要先把fk -> array(elem)存下来，然后根据数据库请求结果将对应fk的指针指向相应的关联对象
-			if orCol.or == "belongs_to" {
-				fk := getPkColumnByType(orCol.orType)
-				if fk == "" {
-					return errors.New("error while getting primary key of " + orCol.table + " for belongs_to")
+		return loadManyRelations(tdx, pkCol, orCols, keys, resMap)
+	}
+	return nil
+}
+
+// selectMap loads the rows of query into the map m points to. Model values,
+// map[K]T or map[K]*T, are keyed by their primary key or else by the field of
+// the first column; other values take the first column as key and the second as value.
+func selectMap(tdx Tdx, m interface{}, query string, args ...interface{}) error {
+	mt := reflect.TypeOf(m)
+	if mt == nil || mt.Kind() != reflect.Ptr || mt.Elem().Kind() != reflect.Map {
+		return errors.New("can not select into a non-pointer map")
+	}
+	mapValue := reflect.ValueOf(m).Elem()
+	if mapValue.IsNil() {
+		mapValue.Set(reflect.MakeMap(mt.Elem()))
+	}
+	kt, vt := mt.Elem().Key(), mt.Elem().Elem()
+	isPtr := vt.Kind() == reflect.Ptr && isModelType(vt.Elem())
+	isStruct := isModelType(vt)
+	if !isPtr && !isStruct {
+		return selectScalarMap(tdx, mapValue, query, args...)
+	}
+	t := vt
+	if isPtr {
+		t = vt.Elem()
+	}
+
+	rows, err := tdx.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	plan := newScanPlan(t, cols)
+	var keyIndex []int
+	if pk, ok := getPkFieldByType(t); ok {
+		keyIndex = pk.Index
+	} else if len(cols) > 0 && plan.fields[0] != nil {
+		keyIndex = plan.fields[0]
+	} else {
+		return errors.New(t.String() + " has no primary key nor a field for the first column to key the map")
+	}
+	if !mapKeyConvertible(t.FieldByIndex(keyIndex).Type, kt) {
+		return errors.New("can not use " + t.FieldByIndex(keyIndex).Type.String() + " as map key " + kt.String())
+	}
+
+	pkCol, orCols := getOrColumnsByType(t)
+	keys := make([]interface{}, 0)
+	resMap := map[interface{}]reflect.Value{}
+	for rows.Next() {
+		v := reflect.New(t)
+		if err := plan.scan(rows, v); err != nil {
+			return err
+		}
+		key := mapKey(v.Elem().FieldByIndex(keyIndex), kt)
+		if isPtr {
+			mapValue.SetMapIndex(key, v)
+			if len(orCols) > 0 {
+				if pkFv := v.Elem().FieldByName(pkCol.Name); pkFv.IsValid() {
+					keys = append(keys, pkFv.Interface())
+					resMap[pkFv.Interface()] = v
 				}
-				fkCol := colName2FieldName(fk)
-				fkValues := make([]interface{}, 0)
-				fkMaps := map[interface{}][]reflect.Value{}
-				i := 0
-				for _, value := range resMap {
-					fkValue, err := getFieldValue(value.Interface(), fkCol)
-					if err != nil {
-						return err
-					}
-					fkValues = append(fkValues, fkValue)
-					if v, ok := fkMaps[fkValue]; ok {
-						fkMaps[fkValue] = append(v, value)
-					} else {
-						fkMaps[fkValue] = make([]reflect.Value, 0)
-						fkMaps[fkValue] = append(fkMaps[fkValue], value)
-					}
-					i = i + 1
+			}
+		} else {
+			mapValue.SetMapIndex(key, v.Elem())
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	// map values are not addressable, relations are only loaded into map[K]*T
+	if len(keys) > 0 {
+		return loadManyRelations(tdx, pkCol, orCols, keys, resMap)
+	}
+	return nil
+}
+
+func isIntKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Uint64
+}
+
+// mapKeyConvertible tells whether values of from key a map of keys to:
+// assignable values, integers of any size, integers formatted as strings,
+// and strings of named types. Convert alone would turn 65 into "A".
+func mapKeyConvertible(from, to reflect.Type) bool {
+	if from.AssignableTo(to) {
+		return true
+	}
+	if isIntKind(from.Kind()) {
+		return isIntKind(to.Kind()) || to.Kind() == reflect.String
+	}
+	return from.Kind() == reflect.String && to.Kind() == reflect.String
+}
+
+// mapKey converts v to the key type kt, checked with mapKeyConvertible.
+func mapKey(v reflect.Value, kt reflect.Type) reflect.Value {
+	if kt.Kind() == reflect.String && isIntKind(v.Kind()) {
+		var s string
+		if v.CanInt() {
+			s = strconv.FormatInt(v.Int(), 10)
+		} else {
+			s = strconv.FormatUint(v.Uint(), 10)
+		}
+		return reflect.ValueOf(s).Convert(kt)
+	}
+	return v.Convert(kt)
+}
+
+func selectScalarMap(tdx Tdx, mapValue reflect.Value, query string, args ...interface{}) error {
+	kt, vt := mapValue.Type().Key(), mapValue.Type().Elem()
+	rows, err := tdx.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if len(cols) != 2 {
+		return fmt.Errorf("map of %s needs a key and a value column, query returns %d columns", vt, len(cols))
+	}
+	for rows.Next() {
+		k, v := reflect.New(kt), reflect.New(vt)
+		if err := rows.Scan(k.Interface(), v.Interface()); err != nil {
+			return err
+		}
+		mapValue.SetMapIndex(k.Elem(), v.Elem())
+	}
+	return rows.Err()
+}
+
+// loadManyRelations fills the or fields of the loaded rows in resMap, keyed by their primary key value.
+func loadManyRelations(tdx Tdx, pkCol reflect.StructField, orCols []*orColumn, keys []interface{}, resMap map[interface{}]reflect.Value) error {
+	for _, orCol := range orCols {
+		var sqlQuery string
+		// 如果是belongs_to，需要先把fk -> array(elem)存下来，然后根据数据库请求结果将对应fk的指针指向相应的关联对象
+		if orCol.or == "belongs_to" {
+			fk := getPkColumnByType(orCol.orType)
+			if fk == "" {
+				return errors.New("error while getting primary key of " + orCol.table + " for belongs_to")
+			}
+			fkCol := colName2FieldName(fk)
+			fkValues := make([]interface{}, 0)
+			fkMaps := map[interface{}][]reflect.Value{}
+			i := 0
+			for _, value := range resMap {
+				fkValue, err := getFieldValue(value.Interface(), fkCol)
+				if err != nil {
+					return err
 				}
-				sqlQuery = makeString("SELECT * FROM "+orCol.table+" WHERE "+fk+" in (",
-					",", ")", fkValues)
-				orRows, err := tdx.Query(sqlQuery)
+				fkValues = append(fkValues, fkValue)
+				if v, ok := fkMaps[fkValue]; ok {
+					fkMaps[fkValue] = append(v, value)
+				} else {
+					fkMaps[fkValue] = make([]reflect.Value, 0)
+					fkMaps[fkValue] = append(fkMaps[fkValue], value)
+				}
+				i = i + 1
+			}
+			sqlQuery = "SELECT * FROM " + orCol.table + " WHERE " + fk + " in (?)" + notDeleted(tdx, orCol.orType)
+			orRows, err := tdx.Query(sqlQuery, fkValues)
 
+			if err != nil {
+				return err
+			}
+			defer orRows.Close()
+			for orRows.Next() {
+				orCols, err := orRows.Columns()
 				if err != nil {
 					return err
 				}
-				defer orRows.Close()
-				for orRows.Next() {
-					orCols, err := orRows.Columns()
-					if err != nil {
-						return err
-					}
-					orValue := reflect.New(orCol.orType)
-					err = reflectStructValue(orValue, orCols, orRows)
-					if err != nil {
-						return err
-					}
-					keyValue := orValue.Elem().FieldByName(fkCol)
-					if keyValue.IsValid() {
-						if arr, ok := fkMaps[keyValue.Interface()]; ok {
-							for _, v := range arr {
-								v.Elem().FieldByName(orCol.fieldName).Set(orValue)
-							}
+				orValue := reflect.New(orCol.orType)
+				err = reflectStructValue(orValue, orCols, orRows)
+				if err != nil {
+					return err
+				}
+				keyValue := orValue.Elem().FieldByName(fkCol)
+				if keyValue.IsValid() {
+					if arr, ok := fkMaps[keyValue.Interface()]; ok {
+						for _, v := range arr {
+							v.Elem().FieldByName(orCol.fieldName).Set(orValue)
 						}
-
 					}
+
 				}
-			} else {
-				sqlQuery = makeString("SELECT * FROM "+orCol.table+" WHERE "+fieldName2ColName(pkCol.Name)+" in (",
-					",", ")", keys)
-				orRows, err := tdx.Query(sqlQuery)
+			}
+		} else {
+			sqlQuery = "SELECT * FROM " + orCol.table + " WHERE " + columnName(pkCol) + " in (?)" + notDeleted(tdx, orCol.orType)
+			orRows, err := tdx.Query(sqlQuery, keys)
 
+			if err != nil {
+				return err
+			}
+			defer orRows.Close()
+
+			for orRows.Next() {
+				orCols, err := orRows.Columns()
 				if err != nil {
 					return err
 				}
-				defer orRows.Close()
-
-				for orRows.Next() {
-					orCols, err := orRows.Columns()
-					if err != nil {
-						return err
-					}
-					orValue := reflect.New(orCol.orType)
-					err = reflectStructValue(orValue, orCols, orRows)
-					if err != nil {
-						return err
-					}
-					keyValue := orValue.Elem().FieldByName(pkCol.Name)
-					if keyValue.IsValid() {
-						if v, ok := resMap[keyValue.Interface()]; ok {
-							if orCol.or == "has_one" {
-								v.Elem().FieldByName(orCol.fieldName).Set(orValue)
-							} else if orCol.or == "has_many" {
-								orSliceValue := v.Elem().FieldByName(orCol.fieldName)
-								orSliceValue.Set(reflect.Append(orSliceValue, orValue))
-							}
+				orValue := reflect.New(orCol.orType)
+				err = reflectStructValue(orValue, orCols, orRows)
+				if err != nil {
+					return err
+				}
+				keyValue := orValue.Elem().FieldByName(pkCol.Name)
+				if keyValue.IsValid() {
+					if v, ok := resMap[keyValue.Interface()]; ok {
+						if orCol.or == "has_one" {
+							v.Elem().FieldByName(orCol.fieldName).Set(orValue)
+						} else if orCol.or == "has_many" {
+							orSliceValue := v.Elem().FieldByName(orCol.fieldName)
+							orSliceValue.Set(reflect.Append(orSliceValue, orValue))
 						}
 					}
 				}
@@ -696,20 +852,6 @@ func selectManyInternal(tdx Tdx, s interface{}, processOr bool, query string, ar
 	return nil
 }
 
-func makeString(start, split, end string, ids []interface{}) string {
-	buff := bytes.Buffer{}
-	buff.WriteString(start)
-	len := len(ids)
-	for i, v := range ids {
-		buff.WriteString(fmt.Sprintf("%v", v))
-		if i < len-1 {
-			buff.WriteString(split)
-		}
-	}
-	buff.WriteString(end)
-	return buff.String()
-}
-
 func columnsByStruct(s interface{}) (string, string, []interface{}, reflect.Value, bool) {
 	t := reflect.TypeOf(s).Elem()
 	v := reflect.ValueOf(s).Elem()
@@ -721,7 +863,7 @@ func columnsByStruct(s interface{}) (string, string, []interface{}, reflect.Valu
 	isAi := false
 	for k := 0; k < t.NumField(); k++ {
 		ft := t.Field(k)
-		cn := fieldName2ColName(ft.Name)
+		cn := columnName(ft)
 
 		//auto increment field
 		if ft.Tag.Get("pk") == "true" {
@@ -756,7 +898,7 @@ func columnsBySlice(s []interface{}) (string, string, []interface{}, []reflect.V
 	isFirst := true
 	for k := 0; k < t.NumField(); k++ {
 		ft := t.Field(k)
-		cn := fieldName2ColName(ft.Name)
+		cn := columnName(ft)
 		if ft.Tag.Get("pk") == "true" {
 			if ft.Tag.Get("ai") == "true" {
 				continue
@@ -777,10 +919,6 @@ func columnsBySlice(s []interface{}) (string, string, []interface{}, []reflect.V
 	pks := make([]reflect.Value, len(s))
 	ais := make([]bool, len(s))
 	for n, record := range s {
-		ct := reflect.TypeOf(record).Elem()
-		if ct.Name() != t.Name() {
-			continue
-		}
 		v := reflect.ValueOf(record).Elem()
 		if n > 0 {
 			vals.WriteString(",")
@@ -817,7 +955,12 @@ func columnsBySlice(s []interface{}) (string, string, []interface{}, []reflect.V
 	return cols, vals.String(), ret, pks, ais
 }
 
-func insert(tdx Tdx, s interface{}, ignore bool) error {
+func insert(tdx Tdx, h *queryHooks, s interface{}, ignore bool) error {
+	if err := beforeInsert(tdx, s); err != nil {
+		return err
+	}
+	touchTimes(s, h.now(), true)
+	initVersion(s)
 	cols, vals, ifs, pk, isAi := columnsByStruct(s)
 	t := reflect.TypeOf(s).Elem()
 
@@ -825,7 +968,7 @@ func insert(tdx Tdx, s interface{}, ignore bool) error {
 	if ignore {
 		prefix += " ignore"
 	}
-	q := fmt.Sprintf("%s into %s (%s) values(%s)", prefix, fieldName2ColName(t.Name()), cols, vals)
+	q := fmt.Sprintf("%s into %s (%s) values(%s)", prefix, GetMapTable(fieldName2ColName(t.Name())), cols, vals)
 	ret, err := tdx.Exec(q, ifs...)
 	if err != nil {
 		return err
@@ -835,18 +978,177 @@ func insert(tdx Tdx, s interface{}, ignore bool) error {
 		if err != nil {
 			return err
 		}
-		if pk.Kind() == reflect.Int64 {
-			pk.SetInt(lid)
+		// insert ignore leaves the last insert id at 0 when the row is skipped
+		if lid != 0 {
+			setAutoIncrementPk(pk, lid)
 		}
 	}
+	if ignore {
+		if ra, err := ret.RowsAffected(); err == nil && ra == 0 {
+			return nil
+		}
+	}
+	return afterInsert(tdx, s)
+}
+
+func setAutoIncrementPk(pk reflect.Value, id int64) {
+	switch pk.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		pk.SetInt(id)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		pk.SetUint(uint64(id))
+	}
+}
+
+// update writes the columns of the model s, but its create time ones, to
+// the row of its primary key.
+func update(tdx Tdx, h *queryHooks, s interface{}) error {
+	if err := beforeUpdate(tdx, s); err != nil {
+		return err
+	}
+	touchTimes(s, h.now(), false)
+	v := reflect.ValueOf(s).Elem()
+	t := v.Type()
+	sets := make([]string, 0, t.NumField())
+	args := make([]interface{}, 0, t.NumField())
+	var pkCol string
+	var pk interface{}
+	for k := 0; k < t.NumField(); k++ {
+		ft := t.Field(k)
+		if ft.Tag.Get("pk") == "true" {
+			pkCol, pk = columnName(ft), v.Field(k).Interface()
+			continue
+		}
+		if ft.Tag.Get("ignore") == "true" || ft.Tag.Get("or") != "" || ft.Tag.Get("version") == "true" || autoTime(ft) == autoCreateTime {
+			continue
+		}
+		sets = append(sets, columnName(ft)+" = ?")
+		args = append(args, v.Field(k).Interface())
+	}
+	if pkCol == "" {
+		return errors.New(getTableName(s) + " does not have primary key")
+	}
+	if err := updateRow(tdx, s, GetMapTable(getTableName(s)), strings.Join(sets, ","), args, pkCol+" = ?", pk); err != nil {
+		return err
+	}
+	return afterUpdate(tdx, s)
+}
+
+// prepareUpsert runs the BeforeInsert hooks of rows and sets their times
+// and first versions, once however often the rows are sent.
+func prepareUpsert(tdx Tdx, now time.Time, rows []interface{}) error {
+	for _, r := range rows {
+		if err := beforeInsert(tdx, r); err != nil {
+			return err
+		}
+		touchTimes(r, now, true)
+		touchTimes(r, now, false)
+		initVersion(r)
+	}
 	return nil
 }
 
-func insertBatch(tdx Tdx, s []interface{}, ignore bool) error {
-	if s == nil || len(s) == 0 {
+// upsert inserts the model s or, when a row with one of its unique keys
+// exists, updates that row but its create time columns and increments its
+// version. The version of an updated row is read back, so that s can be
+// updated next.
+func upsert(tdx Tdx, h *queryHooks, s interface{}) error {
+	if err := prepareUpsert(tdx, h.now(), []interface{}{s}); err != nil {
+		return err
+	}
+	cols, vals, ifs, pk, isAi := columnsByStruct(s)
+	sets := upsertSets(reflect.TypeOf(s).Elem(), nil)
+	table := GetMapTable(getTableName(s))
+	pkCol := getPKColumn(s)
+	q := fmt.Sprintf("insert ignore into %s (%s) values(%s)", table, cols, vals)
+	if len(sets) > 0 {
+		if isAi {
+			// makes the last insert id that of the updated row
+			sets = append(sets, pkCol+" = last_insert_id("+pkCol+")")
+		}
+		q = fmt.Sprintf("insert into %s (%s) values(%s) on duplicate key update %s", table, cols, vals, strings.Join(sets, ","))
+	}
+	ret, err := tdx.Exec(q, ifs...)
+	if err != nil {
+		return err
+	}
+	ra, err := ret.RowsAffected()
+	if err != nil {
+		return err
+	}
+	// MySQL counts 1 affected row for an insert and 2 for an update
+	if isAi && (ra == 1 || (ra == 2 && len(sets) > 0)) {
+		lid, err := ret.LastInsertId()
+		if err != nil {
+			return err
+		}
+		setAutoIncrementPk(pk, lid)
+	}
+	if ra == 1 {
+		return afterInsert(tdx, s)
+	}
+	mv := getVersion(s)
+	if ra != 2 || mv == nil || pkCol == "" {
 		return nil
 	}
-	//todo 需要check s中的数据都是同一种类型
+	n, err := selectInt(tdx, fmt.Sprintf("select %s from %s where %s = ?", mv.col, table, pkCol), pk.Interface())
+	if err == sql.ErrNoRows {
+		// the row of another unique key than the primary key was updated
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	mv.set(n)
+	return nil
+}
+
+// upsertSets returns the on duplicate key update clauses of the model type
+// t overwriting the columns in only, all of them when only is nil. Create
+// times are kept, update times always written and the version incremented.
+// The deletion mark is kept too, so an upsert does not restore a row.
+func upsertSets(t reflect.Type, only map[string]bool) []string {
+	deleted, _, _ := softDeleteField(t)
+	sets := make([]string, 0, t.NumField())
+	for k := 0; k < t.NumField(); k++ {
+		ft := t.Field(k)
+		if ft.Tag.Get("pk") == "true" || ft.Tag.Get("ignore") == "true" || ft.Tag.Get("or") != "" || autoTime(ft) == autoCreateTime {
+			continue
+		}
+		c := columnName(ft)
+		if c == deleted {
+			continue
+		}
+		if ft.Tag.Get("version") == "true" {
+			sets = append(sets, c+" = "+c+" + 1")
+		} else if only == nil || only[c] || autoTime(ft) == autoUpdateTime {
+			sets = append(sets, c+" = values("+c+")")
+		}
+	}
+	return sets
+}
+
+// checkBatch makes sure every element of s is a non-nil pointer to the same struct type.
+func checkBatch(s []interface{}) error {
+	t := reflect.TypeOf(s[0])
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("batch elements should be struct pointers, got %T", s[0])
+	}
+	for i, record := range s {
+		if reflect.TypeOf(record) != t {
+			return fmt.Errorf("batch element %d is %T, expected %T", i, record, s[0])
+		}
+		if reflect.ValueOf(record).IsNil() {
+			return fmt.Errorf("batch element %d is nil", i)
+		}
+	}
+	return nil
+}
+
+// insertBatch inserts s, already checked by checkBatch, with one statement
+// and returns the number of rows inserted. The auto increment ids of
+// consecutive rows differ by step.
+func insertBatch(tdx Tdx, s []interface{}, ignore bool, step int64) (int64, error) {
 	cols, vals, ifs, pks, ais := columnsBySlice(s)
 	t := reflect.TypeOf(s[0]).Elem()
 
@@ -858,19 +1160,28 @@ func insertBatch(tdx Tdx, s []interface{}, ignore bool) error {
 	q := fmt.Sprintf("%s into %s %s values %s", prefix, GetMapTable(fieldName2ColName(t.Name())), cols, vals)
 	ret, err := tdx.Exec(q, ifs...)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	ra, err := ret.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if ra < int64(len(s)) {
+		// insert ignore skipped some rows, MySQL does not tell which ones so
+		// no id can be set
+		return ra, nil
 	}
 	//获取批量插入的last insert id, 然后给每个s[i]主键赋值
 	lastInsertId, err := ret.LastInsertId()
 	if err != nil {
-		return err
+		return ra, err
 	}
 	for i, _ := range s {
 		if ais[i] {
-			pks[i].SetInt(lastInsertId + int64(i))
+			setAutoIncrementPk(pks[i], lastInsertId+int64(i)*step)
 		}
 	}
-	return nil
+	return ra, nil
 }
 
 type ORMer interface {
@@ -881,14 +1192,47 @@ type ORMer interface {
 	SelectInt(string, ...interface{}) (int64, error)
 	Insert(interface{}, bool) error
 	InsertBatch([]interface{}, bool) error
+	Update(interface{}) error
+	Upsert(interface{}) error
+	Delete(interface{}) error
+	Restore(interface{}) error
 	Exec(string, ...interface{}) (sql.Result, error)
 	ExecWithParam(string, interface{}) (sql.Result, error)
+	SelectOneWithParam(interface{}, string, interface{}) error
+	SelectWithParam(interface{}, string, interface{}) error
+	SelectStrWithParam(string, interface{}) (string, error)
+	SelectIntWithParam(string, interface{}) (int64, error)
+	SelectRawWithParam(string, interface{}) ([]string, [][]string, error)
+	SelectRawSetWithParam(string, interface{}) ([]map[string]string, error)
 	ExecWithRowAffectCheck(int64, string, ...interface{}) error
-}
+	SelectRawSet(string, ...interface{}) ([]map[string]string, error)
+	SelectRaw(string, ...interface{}) ([]string, [][]string, error)
+	CheckTables() ([]*SchemaDiff, error)
+	GetTableByName(string) interface{}
+	TruncateTable(string) error
+	TruncateTables() error
+	Cursor(string, ...interface{}) (*Cursor, error)
+	Iterate(interface{}, string, ...interface{}) error
+	InsertBatchResult([]interface{}, bool) (*BatchResult, error)
+	SelectMap(interface{}, string, ...interface{}) error
+	SelectValues(string, ...interface{}) ([]string, [][]interface{}, error)
+	SelectValueSet(string, ...interface{}) ([]map[string]interface{}, error)
+	ExportQuery(io.Writer, ExportFormat, string, ...interface{}) (int64, error)
+	FindInBatches(interface{}, int, string, []interface{}, func(interface{}) error) error
+	Import(interface{}, io.Reader, ExportFormat, ImportOptions) (*ImportResult, error)
+}
+
+var (
+	_ ORMer = (*ORM)(nil)
+	_ ORMer = (*ORMTran)(nil)
+)
 
 type ORM struct {
-	db     *sql.DB
-	tables map[string]interface{}
+	db       *sql.DB
+	tables   map[string]interface{}
+	hooks    *queryHooks
+	ctx      context.Context
+	unscoped bool
 }
 
 var maptables = make(map[string]string)
@@ -910,12 +1254,10 @@ func GetMapTable(typename string) string {
 }
 
 func NewORM(ds string) *ORM {
-	initOnce.Do(func() {
-		sqlParamReg, _ = regexp.Compile("(#{[a-zA-Z0-9-_]*})")
-	})
 	ret := &ORM{
 		db:     nil,
 		tables: make(map[string]interface{}),
+		hooks:  &queryHooks{},
 	}
 	var err error
 	ret.db, err = sql.Open("mysql", ds)
@@ -936,17 +1278,20 @@ func (o *ORM) AddTable(s interface{}) {
 	o.tables[name] = s
 }
 
-func (o *ORM) CheckTables() {
-	for _, s := range o.tables {
-		err := checkTableColumns(o.db, s)
+func checkTables(tdx Tdx, tables map[string]interface{}) ([]*SchemaDiff, error) {
+	ret := make([]*SchemaDiff, 0, len(tables))
+	for _, s := range sortedTables(tables) {
+		diff, err := diffTable(tdx, s)
 		if err != nil {
-			log.Fatalln("can not pass table check:", err)
+			return ret, err
 		}
+		ret = append(ret, diff)
 	}
+	return ret, nil
 }
 
-func (o *ORM) GetTableByName(name string) interface{} {
-	ret, ok := o.tables[name]
+func getTableByName(tables map[string]interface{}, name string) interface{} {
+	ret, ok := tables[name]
 	if !ok {
 		return nil
 	} else {
@@ -954,14 +1299,14 @@ func (o *ORM) GetTableByName(name string) interface{} {
 	}
 }
 
-func (o *ORM) TruncateTable(t string) error {
-	_, err := o.db.Exec("truncate table " + t)
+func truncateTable(tdx Tdx, t string) error {
+	_, err := tdx.Exec("truncate table " + t)
 	return err
 }
 
-func (o *ORM) TruncateTables() error {
-	for t, _ := range o.tables {
-		err := o.TruncateTable(t)
+func truncateTables(tdx Tdx, tables map[string]interface{}) error {
+	for t, _ := range tables {
+		err := truncateTable(tdx, GetMapTable(t))
 		if err != nil {
 			return err
 		}
@@ -969,84 +1314,220 @@ func (o *ORM) TruncateTables() error {
 	return nil
 }
 
+// CheckTables compares every table registered with AddTable to its model,
+// see SchemaDiff. The error only reports a failure to read the schema.
+func (o *ORM) CheckTables() ([]*SchemaDiff, error) {
+	return checkTables(o.tdx(), o.tables)
+}
+
+func (o *ORM) GetTableByName(name string) interface{} {
+	return getTableByName(o.tables, name)
+}
+
+func (o *ORM) TruncateTable(t string) error {
+	return truncateTable(o.tdx(), t)
+}
+
+func (o *ORM) TruncateTables() error {
+	return truncateTables(o.tdx(), o.tables)
+}
+
 func (o *ORM) Begin() (*ORMTran, error) {
-	tx, err := o.db.Begin()
-	return &ORMTran{tx: tx}, err
+	var tx *sql.Tx
+	var err error
+	if o.ctx != nil {
+		tx, err = o.db.BeginTx(o.ctx, nil)
+	} else {
+		tx, err = o.db.Begin()
+	}
+	return &ORMTran{tx: tx, tables: o.tables, hooks: o.hooks, ctx: o.ctx, unscoped: o.unscoped}, err
+}
+
+func (o *ORM) tdx() Tdx {
+	return scope(o.hooks.wrap(o.ctx, o.db), o.unscoped)
+}
+
+func (o *ORM) startOp(name, table, query string) (Tdx, *opSpan) {
+	tdx, span := o.hooks.startOp(o.ctx, o.db, name, table, query)
+	return scope(tdx, o.unscoped), span
 }
 
 func (o *ORM) SelectOne(s interface{}, query string, args ...interface{}) error {
-	return selectOne(o.db, s, query, args...)
+	tdx, span := o.startOp("SelectOne", getTableName(s), query)
+	err := selectOne(tdx, s, query, args...)
+	span.end(oneRow(err), err)
+	return err
 }
 
 func (o *ORM) SelectByPK(s interface{}, pk interface{}) error {
-	return selectByPK(o.db, s, pk)
+	tdx, span := o.startOp("SelectByPK", getTableName(s), "")
+	err := selectByPK(tdx, s, pk)
+	span.end(oneRow(err), err)
+	return err
 }
 
 func (o *ORM) Select(s interface{}, query string, args ...interface{}) error {
-	return selectMany(o.db, s, query, args...)
+	tdx, span := o.startOp("Select", "", query)
+	err := selectMany(tdx, s, query, args...)
+	span.end(resultRows(s), err)
+	return err
+}
+
+// SelectMap loads the rows of query into m, a pointer to map[K]*T or
+// map[K]T keyed by primary key, or to map[K]V built from a key and a value column.
+func (o *ORM) SelectMap(m interface{}, query string, args ...interface{}) error {
+	tdx, span := o.startOp("SelectMap", "", query)
+	err := selectMap(tdx, m, query, args...)
+	span.end(resultRows(m), err)
+	return err
 }
 
 func (o *ORM) SelectRawSet(query string, args ...interface{}) ([]map[string]string, error) {
-	return selectRawSet(o.db, query, args...)
+	tdx, span := o.startOp("SelectRawSet", "", query)
+	ret, err := selectRawSet(tdx, o.hooks.rawFormat, query, args...)
+	span.end(int64(len(ret)), err)
+	return ret, err
 }
 
 func (o *ORM) SelectRaw(query string, args ...interface{}) ([]string, [][]string, error) {
-	return selectRaw(o.db, query, args...)
+	tdx, span := o.startOp("SelectRaw", "", query)
+	cols, data, err := selectRaw(tdx, o.hooks.rawFormat, query, args...)
+	span.end(int64(len(data)), err)
+	return cols, data, err
 }
 
 func (o *ORM) SelectStr(query string, args ...interface{}) (string, error) {
-	return selectStr(o.db, query, args...)
+	tdx, span := o.startOp("SelectStr", "", query)
+	ret, err := selectStr(tdx, query, args...)
+	span.end(oneRow(err), err)
+	return ret, err
 }
 
 func (o *ORM) SelectInt(query string, args ...interface{}) (int64, error) {
-	return selectInt(o.db, query, args...)
+	tdx, span := o.startOp("SelectInt", "", query)
+	ret, err := selectInt(tdx, query, args...)
+	span.end(oneRow(err), err)
+	return ret, err
 }
 
 func (o *ORM) Insert(s interface{}, ignore bool) error {
-	return insert(o.db, s, ignore)
+	if hasAfterHook(s, afterInserterType) {
+		return o.inTx(func(tx *ORMTran) error { return tx.Insert(s, ignore) })
+	}
+	tdx, span := o.startOp("Insert", getTableName(s), "")
+	err := insert(tdx, o.hooks, s, ignore)
+	span.end(oneRow(err), err)
+	return err
 }
 
-func (o *ORM) InsertBatch(s []interface{}, ignore bool) error {
-	return insertBatch(o.db, s, ignore)
+// Update writes every column of s but the create time ones to the row of
+// its primary key.
+func (o *ORM) Update(s interface{}) error {
+	if hasAfterHook(s, afterUpdaterType) {
+		return o.inTx(func(tx *ORMTran) error { return tx.Update(s) })
+	}
+	tdx, span := o.startOp("Update", getTableName(s), "")
+	err := update(tdx, o.hooks, s)
+	span.end(oneRow(err), err)
+	return err
 }
 
-func (o *ORM) ExecWithRowAffectCheck(n int64, query string, args ...interface{}) error {
-	return execWithRowAffectCheck(o.db, n, query, args...)
+// Upsert inserts s, or updates the row holding one of its unique keys.
+// The auto increment pk is not written, so it can not be the key matched.
+// After an update s holds the id and the version of that row.
+func (o *ORM) Upsert(s interface{}) error {
+	if hasAfterHook(s, afterInserterType) {
+		return o.inTx(func(tx *ORMTran) error { return tx.Upsert(s) })
+	}
+	tdx, span := o.startOp("Upsert", getTableName(s), "")
+	err := upsert(tdx, o.hooks, s)
+	span.end(oneRow(err), err)
+	return err
 }
 
-func (o *ORM) Exec(query string, args ...interface{}) (sql.Result, error) {
-	return exec(o.db, query, args...)
+// Delete deletes the row of s by its primary key, or marks it deleted when
+// s has a soft delete field.
+func (o *ORM) Delete(s interface{}) error {
+	if hasAfterHook(s, afterDeleterType) {
+		return o.inTx(func(tx *ORMTran) error { return tx.Delete(s) })
+	}
+	tdx, span := o.startOp("Delete", getTableName(s), "")
+	err := deleteRow(tdx, o.hooks, s)
+	span.end(oneRow(err), err)
+	return err
 }
 
-func (o *ORM) ExecWithParam(paramQuery string, paramMap interface{}) (sql.Result, error) {
-	return execWithParam(o.db, paramQuery, paramMap)
+// Restore clears the deletion mark of the row of s.
+func (o *ORM) Restore(s interface{}) error {
+	tdx, span := o.startOp("Restore", getTableName(s), "")
+	err := restore(tdx, s)
+	span.end(oneRow(err), err)
+	return err
+}
+
+// InsertBatch inserts s with multi-row statements and sets the auto
+// increment ids. With ignore, rows of models with an auto increment id are
+// inserted in a transaction: a statement that skipped rows is rolled back
+// and its rows sent one by one, so that the inserted ones get their ids and
+// the skipped ones keep 0; use InsertBatchResult to know which.
+func (o *ORM) InsertBatch(s []interface{}, ignore bool) error {
+	_, err := o.insertBatch(s, ignore, false)
+	return err
 }
 
-func getFieldValue(param interface{}, fieldName string) (interface{}, error) {
-	v := reflect.ValueOf(param)
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
+// InsertBatchResult works like InsertBatch and reports which rows insert
+// ignore skipped. Since MySQL does not tell which rows of a multi-row insert
+// ignore were skipped, the rows of a statement that skipped some are sent
+// again one by one in the same way.
+func (o *ORM) InsertBatchResult(s []interface{}, ignore bool) (*BatchResult, error) {
+	return o.insertBatch(s, ignore, true)
+}
+
+func (o *ORM) insertBatch(s []interface{}, ignore bool, report bool) (*BatchResult, error) {
+	if len(s) == 0 {
+		return &BatchResult{}, nil
 	}
-	if v.Kind() == reflect.Map {
-		fv := reflect.ValueOf(fieldName)
-		f := v.MapIndex(fv)
-		if f.IsValid() {
-			return f.Interface(), nil
-		} else {
-			return nil, errors.New("missing field " + fieldName)
+	if err := checkBatch(s); err != nil {
+		return nil, err
+	}
+	chunks := splitBatch(s, o.hooks.batch)
+	if (len(chunks) > 1 && o.hooks.batch.InTransaction) || hasAfterHook(s[0], afterInserterType) || (ignore && needsSkippedRows(s[0], report)) {
+		tx, err := o.Begin()
+		if err != nil {
+			return nil, err
 		}
-	} else if v.Kind() == reflect.Struct {
-		f := v.FieldByName(fieldName)
-		var x []int
-		x = append(x, 6)
-		if f.IsValid() {
-			return f.Interface(), nil
-		} else {
-			return nil, errors.New("missing field " + fieldName)
+		ret, err := tx.insertBatch(s, ignore, report)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
 		}
-	} else {
-		return nil, errors.New(fmt.Sprintf("input interface type {%v} is not supported", v.Kind().String()))
+		return ret, tx.Commit()
 	}
+	tdx, span := o.startOp("InsertBatch", getTableName(s[0]), "")
+	ret, err := insertChunks(tdx, o.hooks, chunks, ignore, report)
+	span.end(ret.Inserted, err)
+	return ret, err
+}
+
+func (o *ORM) ExecWithRowAffectCheck(n int64, query string, args ...interface{}) error {
+	tdx, span := o.startOp("ExecWithRowAffectCheck", "", query)
+	ra, err := execRowAffectCheck(tdx, n, query, args...)
+	span.end(ra, err)
+	return err
+}
+
+func (o *ORM) Exec(query string, args ...interface{}) (sql.Result, error) {
+	tdx, span := o.startOp("Exec", "", query)
+	ret, err := exec(tdx, query, args...)
+	span.end(rowsAffected(ret), err)
+	return ret, err
+}
+
+func (o *ORM) ExecWithParam(paramQuery string, paramMap interface{}) (sql.Result, error) {
+	tdx, span := o.startOp("ExecWithParam", "", paramQuery)
+	ret, err := execWithParam(tdx, o.hooks, paramQuery, paramMap)
+	span.end(rowsAffected(ret), err)
+	return ret, err
 }
 
 func (o *ORM) DoTransaction(f func(*ORMTran) error) error {
@@ -1089,55 +1570,183 @@ func (o *ORM) DoTransactionMore(f func(*ORMTran) (interface{}, error)) (interfac
 }
 
 type ORMTran struct {
-	tx *sql.Tx
+	tx       *sql.Tx
+	tables   map[string]interface{}
+	hooks    *queryHooks
+	ctx      context.Context
+	unscoped bool
+}
+
+func (o *ORMTran) tdx() Tdx {
+	return scope(o.hooks.wrap(o.ctx, o.tx), o.unscoped)
+}
+
+func (o *ORMTran) startOp(name, table, query string) (Tdx, *opSpan) {
+	tdx, span := o.hooks.startOp(o.ctx, o.tx, name, table, query)
+	return scope(tdx, o.unscoped), span
 }
 
 func (o *ORMTran) SelectOne(s interface{}, query string, args ...interface{}) error {
-	return selectOne(o.tx, s, query, args...)
+	tdx, span := o.startOp("SelectOne", getTableName(s), query)
+	err := selectOne(tdx, s, query, args...)
+	span.end(oneRow(err), err)
+	return err
 }
 
 func (o *ORMTran) Insert(s interface{}, ignore bool) error {
-	return insert(o.tx, s, ignore)
+	tdx, span := o.startOp("Insert", getTableName(s), "")
+	err := insert(tdx, o.hooks, s, ignore)
+	span.end(oneRow(err), err)
+	return err
+}
+
+func (o *ORMTran) Update(s interface{}) error {
+	tdx, span := o.startOp("Update", getTableName(s), "")
+	err := update(tdx, o.hooks, s)
+	span.end(oneRow(err), err)
+	return err
+}
+
+func (o *ORMTran) Upsert(s interface{}) error {
+	tdx, span := o.startOp("Upsert", getTableName(s), "")
+	err := upsert(tdx, o.hooks, s)
+	span.end(oneRow(err), err)
+	return err
+}
+
+func (o *ORMTran) Delete(s interface{}) error {
+	tdx, span := o.startOp("Delete", getTableName(s), "")
+	err := deleteRow(tdx, o.hooks, s)
+	span.end(oneRow(err), err)
+	return err
+}
+
+func (o *ORMTran) Restore(s interface{}) error {
+	tdx, span := o.startOp("Restore", getTableName(s), "")
+	err := restore(tdx, s)
+	span.end(oneRow(err), err)
+	return err
 }
 
 func (o *ORMTran) InsertBatch(s []interface{}, ignore bool) error {
-	return insertBatch(o.tx, s, ignore)
+	_, err := o.insertBatch(s, ignore, false)
+	return err
+}
+
+func (o *ORMTran) InsertBatchResult(s []interface{}, ignore bool) (*BatchResult, error) {
+	return o.insertBatch(s, ignore, true)
+}
+
+func (o *ORMTran) insertBatch(s []interface{}, ignore bool, report bool) (*BatchResult, error) {
+	if len(s) == 0 {
+		return &BatchResult{}, nil
+	}
+	if err := checkBatch(s); err != nil {
+		return nil, err
+	}
+	tdx, span := o.startOp("InsertBatch", getTableName(s[0]), "")
+	ret, err := insertChunks(tdx, o.hooks, splitBatch(s, o.hooks.batch), ignore, report)
+	span.end(ret.Inserted, err)
+	return ret, err
 }
 
 func (o *ORMTran) Exec(query string, args ...interface{}) (sql.Result, error) {
-	return exec(o.tx, query, args...)
+	tdx, span := o.startOp("Exec", "", query)
+	ret, err := exec(tdx, query, args...)
+	span.end(rowsAffected(ret), err)
+	return ret, err
 }
 
 func (o *ORMTran) Commit() error {
-	return o.tx.Commit()
+	err := o.tx.Commit()
+	o.hooks.observeTx("commit", err)
+	return err
 }
 
 func (o *ORMTran) Rollback() error {
-	return o.tx.Rollback()
+	err := o.tx.Rollback()
+	o.hooks.observeTx("rollback", err)
+	return err
 }
 
 func (o *ORMTran) SelectByPK(s interface{}, pk interface{}) error {
-	return selectByPK(o.tx, s, pk)
+	tdx, span := o.startOp("SelectByPK", getTableName(s), "")
+	err := selectByPK(tdx, s, pk)
+	span.end(oneRow(err), err)
+	return err
 }
 
 func (o *ORMTran) Select(s interface{}, query string, args ...interface{}) error {
-	return selectMany(o.tx, s, query, args...)
+	tdx, span := o.startOp("Select", "", query)
+	err := selectMany(tdx, s, query, args...)
+	span.end(resultRows(s), err)
+	return err
 }
 
 func (o *ORMTran) SelectInt(query string, args ...interface{}) (int64, error) {
-	return selectInt(o.tx, query, args...)
+	tdx, span := o.startOp("SelectInt", "", query)
+	ret, err := selectInt(tdx, query, args...)
+	span.end(oneRow(err), err)
+	return ret, err
 }
 
 func (o *ORMTran) SelectStr(query string, args ...interface{}) (string, error) {
-	return selectStr(o.tx, query, args...)
+	tdx, span := o.startOp("SelectStr", "", query)
+	ret, err := selectStr(tdx, query, args...)
+	span.end(oneRow(err), err)
+	return ret, err
 }
 
 func (o *ORMTran) ExecWithParam(paramQuery string, paramMap interface{}) (sql.Result, error) {
-	return execWithParam(o.tx, paramQuery, paramMap)
+	tdx, span := o.startOp("ExecWithParam", "", paramQuery)
+	ret, err := execWithParam(tdx, o.hooks, paramQuery, paramMap)
+	span.end(rowsAffected(ret), err)
+	return ret, err
 }
 
 func (o *ORMTran) ExecWithRowAffectCheck(n int64, query string, args ...interface{}) error {
-	return execWithRowAffectCheck(o.tx, n, query, args...)
+	tdx, span := o.startOp("ExecWithRowAffectCheck", "", query)
+	ra, err := execRowAffectCheck(tdx, n, query, args...)
+	span.end(ra, err)
+	return err
+}
+
+func (o *ORMTran) SelectMap(m interface{}, query string, args ...interface{}) error {
+	tdx, span := o.startOp("SelectMap", "", query)
+	err := selectMap(tdx, m, query, args...)
+	span.end(resultRows(m), err)
+	return err
+}
+
+func (o *ORMTran) SelectRawSet(query string, args ...interface{}) ([]map[string]string, error) {
+	tdx, span := o.startOp("SelectRawSet", "", query)
+	ret, err := selectRawSet(tdx, o.hooks.rawFormat, query, args...)
+	span.end(int64(len(ret)), err)
+	return ret, err
+}
+
+func (o *ORMTran) SelectRaw(query string, args ...interface{}) ([]string, [][]string, error) {
+	tdx, span := o.startOp("SelectRaw", "", query)
+	cols, data, err := selectRaw(tdx, o.hooks.rawFormat, query, args...)
+	span.end(int64(len(data)), err)
+	return cols, data, err
+}
+
+func (o *ORMTran) CheckTables() ([]*SchemaDiff, error) {
+	return checkTables(o.tdx(), o.tables)
+}
+
+func (o *ORMTran) GetTableByName(name string) interface{} {
+	return getTableByName(o.tables, name)
+}
+
+// TruncateTable在MySQL中会隐式提交当前事务.
+func (o *ORMTran) TruncateTable(t string) error {
+	return truncateTable(o.tdx(), t)
+}
+
+func (o *ORMTran) TruncateTables() error {
+	return truncateTables(o.tdx(), o.tables)
 }
 
 func IsRowAffectError(err error) bool {
@@ -0,0 +1,317 @@
+package orm
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// expandParams replaces the named parameters of paramQuery, written #{Name}
+// or :Name, by placeholders and returns the values they take from param.
+// Names may be paths through nested structs and maps, like #{User.ID}.
+// Slices expand to one placeholder per element for IN clauses. Quoted text
+// is left alone.
+func expandParams(paramQuery string, param interface{}) (string, []interface{}, error) {
+	var buf strings.Builder
+	args := make([]interface{}, 0)
+	for i := 0; i < len(paramQuery); i++ {
+		c := paramQuery[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := quoteEnd(paramQuery, i)
+			buf.WriteString(paramQuery[i:end])
+			i = end - 1
+			continue
+		case c == '#' && i+1 < len(paramQuery) && paramQuery[i+1] == '{':
+			end := strings.IndexByte(paramQuery[i:], '}')
+			if end < 0 {
+				return "", nil, errors.New("unclosed parameter in " + paramQuery)
+			}
+			name := paramQuery[i+2 : i+end]
+			value, err := getFieldValue(param, name)
+			if err != nil {
+				return "", nil, err
+			}
+			args = writeParam(&buf, paramQuery, i, i+end+1, value, args)
+			i += end
+			continue
+		case c == ':' && (i == 0 || paramQuery[i-1] != ':') && i+1 < len(paramQuery) && isParamStart(paramQuery[i+1]):
+			end := i + 1
+			for end < len(paramQuery) && (isParamStart(paramQuery[end]) || paramQuery[end] >= '0' && paramQuery[end] <= '9' || paramQuery[end] == '.') {
+				end++
+			}
+			if paramQuery[end-1] == '.' {
+				end--
+			}
+			value, err := getFieldValue(param, paramQuery[i+1:end])
+			if err != nil {
+				return "", nil, err
+			}
+			args = writeParam(&buf, paramQuery, i, end, value, args)
+			i = end - 1
+			continue
+		}
+		buf.WriteByte(c)
+	}
+	return buf.String(), args, nil
+}
+
+// expandSliceArgs rewrites the ? placeholders of query bound to slices, like
+// id in (?) with a []int64, into one placeholder per element.
+func expandSliceArgs(query string, args []interface{}) (string, []interface{}) {
+	hasSlice := false
+	for _, a := range args {
+		if _, ok := sliceArg(a); ok {
+			hasSlice = true
+			break
+		}
+	}
+	if !hasSlice {
+		return query, args
+	}
+	var buf strings.Builder
+	ret := make([]interface{}, 0, len(args))
+	n := 0
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := quoteEnd(query, i)
+			buf.WriteString(query[i:end])
+			i = end - 1
+			continue
+		case c == '?' && n < len(args):
+			ret = writeParam(&buf, query, i, i+1, args[n], ret)
+			n++
+			continue
+		}
+		buf.WriteByte(c)
+	}
+	// a count mismatch is left for the driver to report
+	return buf.String(), append(ret, args[n:]...)
+}
+
+// sliceArgsTdx expands slice arguments for a Tdx without hooks.
+type sliceArgsTdx struct {
+	Tdx
+}
+
+func (t sliceArgsTdx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	query, args = expandSliceArgs(query, args)
+	return t.Tdx.Exec(query, args...)
+}
+
+func (t sliceArgsTdx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	query, args = expandSliceArgs(query, args)
+	return t.Tdx.Query(query, args...)
+}
+
+func isParamStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+// quoteEnd returns the index after the quoted text starting at start.
+func quoteEnd(query string, start int) int {
+	q := query[start]
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			i++
+		case q:
+			if i+1 < len(query) && query[i+1] == q {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// writeParam writes the placeholders of the parameter query[start:end] with
+// value. A slice already in parentheses, like in (#{IDs}), is not wrapped
+// again.
+func writeParam(buf *strings.Builder, query string, start, end int, value interface{}, args []interface{}) []interface{} {
+	elems, ok := sliceArg(value)
+	if !ok {
+		buf.WriteByte('?')
+		return append(args, value)
+	}
+	before := strings.TrimRight(query[:start], " \t\n")
+	after := strings.TrimLeft(query[end:], " \t\n")
+	wrapped := strings.HasSuffix(before, "(") && strings.HasPrefix(after, ")")
+	if !wrapped {
+		buf.WriteByte('(')
+	}
+	if len(elems) == 0 {
+		// in (NULL) matches nothing, in () is a syntax error
+		buf.WriteString("NULL")
+	} else {
+		buf.WriteString(strings.Repeat(",?", len(elems))[1:])
+	}
+	if !wrapped {
+		buf.WriteByte(')')
+	}
+	return append(args, elems...)
+}
+
+// sliceArg returns the elements of v when it is a slice or an array bound to
+// an IN list. []byte and driver.Valuer values are scalars.
+func sliceArg(v interface{}) ([]interface{}, bool) {
+	if v == nil {
+		return nil, false
+	}
+	if _, ok := v.(driver.Valuer); ok {
+		return nil, false
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array || rv.Type().Elem().Kind() == reflect.Uint8 {
+		return nil, false
+	}
+	ret := make([]interface{}, rv.Len())
+	for i := range ret {
+		ret[i] = rv.Index(i).Interface()
+	}
+	return ret, true
+}
+
+// getFieldValue returns the value at the dotted path fieldName in param, a
+// struct or a map keyed by strings, or pointers to them. Struct fields may
+// also be named by column, like user_id.
+func getFieldValue(param interface{}, fieldName string) (interface{}, error) {
+	v := reflect.ValueOf(param)
+	for _, name := range strings.Split(fieldName, ".") {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return nil, errors.New("nil value on the path to " + fieldName)
+			}
+			v = v.Elem()
+		}
+		var f reflect.Value
+		switch v.Kind() {
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return nil, errors.New("map keys must be strings for " + fieldName)
+			}
+			f = v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+		case reflect.Struct:
+			f = v.FieldByName(name)
+			if !f.IsValid() {
+				f = v.FieldByName(colName2FieldName(name))
+			}
+		default:
+			return nil, fmt.Errorf("input interface type {%v} is not supported", v.Kind().String())
+		}
+		if !f.IsValid() {
+			return nil, errors.New("missing field " + fieldName)
+		}
+		v = f
+	}
+	if !v.CanInterface() {
+		return nil, errors.New("unexported field " + fieldName)
+	}
+	return v.Interface(), nil
+}
+
+// SelectOneWithParam works like SelectOne with the named parameters of
+// ExecWithParam: #{Name} or :Name, nested paths like #{User.ID}, and slices
+// expanded for IN clauses.
+func (o *ORM) SelectOneWithParam(s interface{}, paramQuery string, param interface{}) error {
+	query, args, err := expandParams(paramQuery, param)
+	if err != nil {
+		return err
+	}
+	return o.SelectOne(s, query, args...)
+}
+
+// SelectWithParam works like Select with the named parameters of SelectOneWithParam.
+func (o *ORM) SelectWithParam(s interface{}, paramQuery string, param interface{}) error {
+	query, args, err := expandParams(paramQuery, param)
+	if err != nil {
+		return err
+	}
+	return o.Select(s, query, args...)
+}
+
+func (o *ORM) SelectStrWithParam(paramQuery string, param interface{}) (string, error) {
+	query, args, err := expandParams(paramQuery, param)
+	if err != nil {
+		return "", err
+	}
+	return o.SelectStr(query, args...)
+}
+
+func (o *ORM) SelectIntWithParam(paramQuery string, param interface{}) (int64, error) {
+	query, args, err := expandParams(paramQuery, param)
+	if err != nil {
+		return 0, err
+	}
+	return o.SelectInt(query, args...)
+}
+
+func (o *ORM) SelectRawWithParam(paramQuery string, param interface{}) ([]string, [][]string, error) {
+	query, args, err := expandParams(paramQuery, param)
+	if err != nil {
+		return nil, nil, err
+	}
+	return o.SelectRaw(query, args...)
+}
+
+func (o *ORM) SelectRawSetWithParam(paramQuery string, param interface{}) ([]map[string]string, error) {
+	query, args, err := expandParams(paramQuery, param)
+	if err != nil {
+		return nil, err
+	}
+	return o.SelectRawSet(query, args...)
+}
+
+func (o *ORMTran) SelectOneWithParam(s interface{}, paramQuery string, param interface{}) error {
+	query, args, err := expandParams(paramQuery, param)
+	if err != nil {
+		return err
+	}
+	return o.SelectOne(s, query, args...)
+}
+
+func (o *ORMTran) SelectWithParam(s interface{}, paramQuery string, param interface{}) error {
+	query, args, err := expandParams(paramQuery, param)
+	if err != nil {
+		return err
+	}
+	return o.Select(s, query, args...)
+}
+
+func (o *ORMTran) SelectStrWithParam(paramQuery string, param interface{}) (string, error) {
+	query, args, err := expandParams(paramQuery, param)
+	if err != nil {
+		return "", err
+	}
+	return o.SelectStr(query, args...)
+}
+
+func (o *ORMTran) SelectIntWithParam(paramQuery string, param interface{}) (int64, error) {
+	query, args, err := expandParams(paramQuery, param)
+	if err != nil {
+		return 0, err
+	}
+	return o.SelectInt(query, args...)
+}
+
+func (o *ORMTran) SelectRawWithParam(paramQuery string, param interface{}) ([]string, [][]string, error) {
+	query, args, err := expandParams(paramQuery, param)
+	if err != nil {
+		return nil, nil, err
+	}
+	return o.SelectRaw(query, args...)
+}
+
+func (o *ORMTran) SelectRawSetWithParam(paramQuery string, param interface{}) ([]map[string]string, error) {
+	query, args, err := expandParams(paramQuery, param)
+	if err != nil {
+		return nil, err
+	}
+	return o.SelectRawSet(query, args...)
+}
@@ -0,0 +1,96 @@
+package orm
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testParamOwner struct {
+	ID int64
+}
+
+type testParam struct {
+	UserId int64
+	Names  []string
+	Owner  *testParamOwner
+	Data   []byte
+}
+
+func TestExpandParams(t *testing.T) {
+	p := &testParam{UserId: 7, Names: []string{"a", "b"}, Owner: &testParamOwner{ID: 3}, Data: []byte("x")}
+	cases := []struct {
+		query string
+		want  string
+		args  []interface{}
+	}{
+		{"select * from u where user_id = #{UserId}", "select * from u where user_id = ?", []interface{}{int64(7)}},
+		{"select * from u where user_id = :user_id and t > '10:30'", "select * from u where user_id = ? and t > '10:30'", []interface{}{int64(7)}},
+		{"select * from u where owner = #{Owner.ID} and data = :Data", "select * from u where owner = ? and data = ?", []interface{}{int64(3), []byte("x")}},
+		{"select * from u where name in #{Names}", "select * from u where name in (?,?)", []interface{}{"a", "b"}},
+		{"select * from u where name in ( :Names ) and id = :Owner.ID.", "select * from u where name in ( ?,? ) and id = ?.", []interface{}{"a", "b", int64(3)}},
+		{"set @a := 1", "set @a := 1", []interface{}{}},
+	}
+	for _, c := range cases {
+		q, args, err := expandParams(c.query, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q != c.want || !reflect.DeepEqual(args, c.args) {
+			t.Fatalf("%s: got %s %v", c.query, q, args)
+		}
+	}
+
+	q, args, err := expandParams("select * from u where name in (#{names})", map[string]interface{}{"names": []int{}})
+	if err != nil || q != "select * from u where name in (NULL)" || len(args) != 0 {
+		t.Fatalf("unexpected empty slice expansion %s %v %v", q, args, err)
+	}
+	if _, _, err := expandParams("select :Missing", p); err == nil {
+		t.Fatal("missing field should be rejected")
+	}
+	if _, _, err := expandParams("select :Owner.ID", &testParam{}); err == nil {
+		t.Fatal("nil pointer on the path should be rejected")
+	}
+}
+
+func TestSelectWithParam(t *testing.T) {
+	fdb := &fakeDB{onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+		return cursorUsers(2), nil
+	}}
+	o := newFakeORM(fdb)
+	var users []*TestCursorUser
+	err := o.SelectWithParam(&users, "select * from test_cursor_user where user_id in #{ids}", map[string][]int64{"ids": {1, 2}})
+	if err != nil || len(users) != 2 {
+		t.Fatalf("unexpected select result %v %v", users, err)
+	}
+	if fdb.queries[0].query != "select * from test_cursor_user where user_id in (?,?)" || len(fdb.queries[0].args) != 2 {
+		t.Fatalf("unexpected query %v", fdb.queries[0])
+	}
+}
+
+func TestExpandSliceArgs(t *testing.T) {
+	q, args := expandSliceArgs("select * from u where id in (?) and name = '?' and data = ?", []interface{}{[]int64{1, 2, 3}, []byte("x")})
+	if q != "select * from u where id in (?,?,?) and name = '?' and data = ?" || len(args) != 4 {
+		t.Fatalf("unexpected expansion %s %v", q, args)
+	}
+	q, args = expandSliceArgs("select * from u where id in (?)", []interface{}{[]string{}})
+	if q != "select * from u where id in (NULL)" || len(args) != 0 {
+		t.Fatalf("unexpected empty expansion %s %v", q, args)
+	}
+
+	fdb := &fakeDB{onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+		return cursorUsers(2), nil
+	}}
+	o := newFakeORM(fdb)
+	var users []*TestCursorUser
+	if err := o.Select(&users, "select * from test_cursor_user where user_id in (?)", []int64{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	// the has_many relation binds its keys the same way
+	for _, q := range fdb.queries {
+		if !strings.HasSuffix(q.query, "in (?,?)") || len(q.args) != 2 {
+			t.Fatalf("unexpected query %v", q)
+		}
+	}
+}
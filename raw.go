@@ -0,0 +1,210 @@
+package orm
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RawFormat controls how SelectRaw and SelectRawSet turn values into strings.
+type RawFormat struct {
+	// FloatFormat and FloatPrecision are passed to strconv.FormatFloat.
+	FloatFormat    byte
+	FloatPrecision int
+	TimeLayout     string
+	// Null is the text of NULL in SelectRaw, SelectRawSet leaves NULL columns out.
+	Null string
+}
+
+// DefaultRawFormat is the format used unless SetRawFormat is called.
+var DefaultRawFormat = RawFormat{
+	FloatFormat:    'f',
+	FloatPrecision: 4,
+	TimeLayout:     "2006-01-02 15:04:05",
+	Null:           "",
+}
+
+// SetRawFormat changes the string format of SelectRaw and SelectRawSet,
+// start from DefaultRawFormat and adjust the fields needed.
+func (o *ORM) SetRawFormat(f RawFormat) {
+	o.hooks.rawFormat = &f
+}
+
+// format returns the text of v, false for NULL. A nil f uses DefaultRawFormat.
+func (f *RawFormat) format(v interface{}) (string, bool) {
+	if f == nil {
+		f = &DefaultRawFormat
+	}
+	switch t := v.(type) {
+	case nil:
+		return f.Null, false
+	case []uint8:
+		return string(t[:]), true
+	case string:
+		return t, true
+	case time.Time:
+		return t.Format(f.TimeLayout), true
+	case int64:
+		return strconv.FormatInt(t, 10), true
+	case int:
+		return strconv.Itoa(t), true
+	case uint64:
+		return strconv.FormatUint(t, 10), true
+	case float32:
+		return strconv.FormatFloat(float64(t), f.FloatFormat, f.FloatPrecision, 32), true
+	case float64:
+		return strconv.FormatFloat(t, f.FloatFormat, f.FloatPrecision, 64), true
+	case bool:
+		return strconv.FormatBool(t), true
+	default:
+		return fmt.Sprint(t), true
+	}
+}
+
+// rawValue converts a value scanned into interface{} to the Go type
+// matching its MySQL column type. NULL stays nil; DECIMAL stays a string to
+// keep its precision; JSON becomes json.RawMessage.
+func rawValue(ct *sql.ColumnType, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, isBytes := v.([]byte)
+	if !isBytes {
+		// the binary protocol already decoded numbers and times
+		return v, nil
+	}
+	text := string(b)
+	dbType := strings.ToUpper(ct.DatabaseTypeName())
+	switch dbType {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "YEAR":
+		return strconv.ParseInt(text, 10, 64)
+	case "UNSIGNED TINYINT", "UNSIGNED SMALLINT", "UNSIGNED MEDIUMINT", "UNSIGNED INT", "UNSIGNED BIGINT":
+		return strconv.ParseUint(text, 10, 64)
+	case "FLOAT", "DOUBLE":
+		return strconv.ParseFloat(text, 64)
+	case "DECIMAL":
+		return text, nil
+	case "DATE":
+		if strings.HasPrefix(text, "0000-00-00") {
+			return time.Time{}, nil
+		}
+		return time.Parse("2006-01-02", text)
+	case "DATETIME", "TIMESTAMP":
+		if strings.HasPrefix(text, "0000-00-00") {
+			return time.Time{}, nil
+		}
+		return time.Parse("2006-01-02 15:04:05.999999999", text)
+	case "JSON":
+		return json.RawMessage(append([]byte(nil), b...)), nil
+	case "BIT", "BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB", "GEOMETRY":
+		return append([]byte(nil), b...), nil
+	}
+	return text, nil
+}
+
+// rawRows reads every row of rows as typed values, see rawValue.
+func rawRows(rows *sql.Rows, fn func(cols []string, values []interface{}) error) error {
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	itemList := make([]interface{}, len(cols))
+	for rows.Next() {
+		for i := range itemList {
+			itemList[i] = new(interface{})
+		}
+		if err := rows.Scan(itemList...); err != nil {
+			return err
+		}
+		values := make([]interface{}, len(cols))
+		for k := range cols {
+			v, err := rawValue(types[k], *itemList[k].(*interface{}))
+			if err != nil {
+				return fmt.Errorf("column %s: %v", cols[k], err)
+			}
+			values[k] = v
+		}
+		if err := fn(cols, values); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func selectValues(tdx Tdx, query string, args ...interface{}) ([]string, [][]interface{}, error) {
+	rows, err := tdx.Query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var colNames []string
+	data := [][]interface{}{}
+	err = rawRows(rows, func(cols []string, values []interface{}) error {
+		colNames = cols
+		data = append(data, values)
+		return nil
+	})
+	if colNames == nil {
+		colNames, _ = rows.Columns()
+	}
+	return colNames, data, err
+}
+
+func selectValueSet(tdx Tdx, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := tdx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dataSet := make([]map[string]interface{}, 0, 1)
+	err = rawRows(rows, func(cols []string, values []interface{}) error {
+		itemMap := make(map[string]interface{}, len(cols))
+		for k, c := range cols {
+			itemMap[colName2FieldName(c)] = values[k]
+		}
+		dataSet = append(dataSet, itemMap)
+		return nil
+	})
+	return dataSet, err
+}
+
+// SelectValues works like SelectRaw, but keeps every value as the Go type of
+// its column: int64, uint64, float64, string, time.Time, []byte,
+// json.RawMessage, or nil for NULL.
+func (o *ORM) SelectValues(query string, args ...interface{}) ([]string, [][]interface{}, error) {
+	tdx, span := o.startOp("SelectValues", "", query)
+	cols, data, err := selectValues(tdx, query, args...)
+	span.end(int64(len(data)), err)
+	return cols, data, err
+}
+
+// SelectValueSet works like SelectRawSet with the typed values of SelectValues.
+func (o *ORM) SelectValueSet(query string, args ...interface{}) ([]map[string]interface{}, error) {
+	tdx, span := o.startOp("SelectValueSet", "", query)
+	ret, err := selectValueSet(tdx, query, args...)
+	span.end(int64(len(ret)), err)
+	return ret, err
+}
+
+func (o *ORMTran) SelectValues(query string, args ...interface{}) ([]string, [][]interface{}, error) {
+	tdx, span := o.startOp("SelectValues", "", query)
+	cols, data, err := selectValues(tdx, query, args...)
+	span.end(int64(len(data)), err)
+	return cols, data, err
+}
+
+func (o *ORMTran) SelectValueSet(query string, args ...interface{}) ([]map[string]interface{}, error) {
+	tdx, span := o.startOp("SelectValueSet", "", query)
+	ret, err := selectValueSet(tdx, query, args...)
+	span.end(int64(len(ret)), err)
+	return ret, err
+}
@@ -0,0 +1,80 @@
+package orm
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func rawTestRows(string, []driver.Value) (*fakeRows, error) {
+	return &fakeRows{
+		cols:  []string{"id", "price", "ratio", "created_at", "note", "doc", "hits", "born"},
+		types: []string{"BIGINT", "DECIMAL", "DOUBLE", "DATETIME", "VARCHAR", "JSON", "UNSIGNED BIGINT", "DATE"},
+		rows: [][]driver.Value{
+			{[]byte("1"), []byte("10.125"), []byte("0.333333"), []byte("2020-01-02 03:04:05.5"), nil, []byte(`{"a":1}`), []byte("18446744073709551615"), []byte("1990-05-06")},
+			{[]byte("2"), []byte("0"), []byte("0"), []byte("0000-00-00 00:00:00"), nil, []byte(`null`), []byte("0"), []byte("0000-00-00")},
+		},
+	}, nil
+}
+
+func TestSelectValues(t *testing.T) {
+	o := newFakeORM(&fakeDB{onQuery: rawTestRows})
+	cols, data, err := o.SelectValues("select * from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cols) != 8 || len(data) != 2 {
+		t.Fatalf("unexpected result %v %v", cols, data)
+	}
+	row := data[0]
+	if row[0] != int64(1) || row[1] != "10.125" || row[2] != 0.333333 || row[4] != nil || row[6] != uint64(18446744073709551615) {
+		t.Fatalf("unexpected values %#v", row)
+	}
+	if ts, ok := row[3].(time.Time); !ok || ts.Nanosecond() != 500000000 {
+		t.Fatalf("unexpected time %#v", row[3])
+	}
+	if doc, ok := row[5].(json.RawMessage); !ok || string(doc) != `{"a":1}` {
+		t.Fatalf("unexpected json %#v", row[5])
+	}
+	if born, ok := row[7].(time.Time); !ok || born.Year() != 1990 {
+		t.Fatalf("unexpected date %#v", row[7])
+	}
+	// zero dates are zero times
+	if zero := data[1]; zero[3] != (time.Time{}) || zero[7] != (time.Time{}) {
+		t.Fatalf("unexpected zero dates %#v", zero)
+	}
+
+	set, err := o.SelectValueSet("select * from t")
+	if err != nil || len(set) != 2 || set[0]["Id"] != int64(1) || set[0]["Note"] != nil {
+		t.Fatalf("unexpected set %v %v", set, err)
+	}
+}
+
+func TestRawFormat(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	o := newFakeORM(&fakeDB{onQuery: func(string, []driver.Value) (*fakeRows, error) {
+		return &fakeRows{
+			cols: []string{"ratio", "created_at", "note", "flag"},
+			rows: [][]driver.Value{{1.0 / 3, ts, nil, true}},
+		}, nil
+	}})
+	_, data, err := o.SelectRaw("select * from t")
+	if err != nil || data[0][0] != "0.3333" || data[0][1] != "2020-01-02 03:04:05" || data[0][3] != "true" {
+		t.Fatalf("unexpected default format %v %v", data, err)
+	}
+
+	f := DefaultRawFormat
+	f.FloatPrecision = -1
+	f.TimeLayout = time.RFC3339
+	f.Null = "NULL"
+	o.SetRawFormat(f)
+	_, data, _ = o.SelectRaw("select * from t")
+	if data[0][0] != "0.3333333333333333" || data[0][1] != "2020-01-02T03:04:05Z" || data[0][2] != "NULL" {
+		t.Fatalf("unexpected custom format %v", data)
+	}
+	set, _ := o.SelectRawSet("select * from t")
+	if _, ok := set[0]["Note"]; ok {
+		t.Fatal("NULL should be left out of SelectRawSet")
+	}
+}
@@ -0,0 +1,493 @@
+package orm
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ColumnDef is a column of a model, read from its field and tags:
+//
+//	size:"64"         length of strings and binaries, precision of times
+//	null:"true"       nullable, the default for pointers and sql.Null types
+//	default:"0"       DEFAULT expression, copied as is: default:"'none'"
+//	unique:"true"     unique index, or unique:"name" to share a composite one
+//	index:"true"      index, or index:"name" to share a composite one
+//	type:"DECIMAL(10,2)" SQL type overriding the Go type mapping
+//
+// ignore:"true" fields are left out of inserts, so their columns need a
+// default or null tag.
+type ColumnDef struct {
+	Name          string
+	GoType        reflect.Type
+	SQLType       string
+	Size          int
+	Nullable      bool
+	Default       *string
+	PrimaryKey    bool
+	AutoIncrement bool
+}
+
+// IndexDef is an index or unique key of a table.
+type IndexDef struct {
+	Name    string
+	Columns []string
+	Unique  bool
+}
+
+// TableDef is the table of a model, see ColumnDef for the tags it reads.
+type TableDef struct {
+	Name    string
+	Columns []*ColumnDef
+	Indexes []*IndexDef
+}
+
+func isNullableType(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		return true
+	}
+	switch t {
+	case nullStringType, nullInt64Type, nullInt32Type, nullInt16Type, nullByteType, nullFloat64Type, nullBoolType, nullTimeType, softDeleteType:
+		return true
+	}
+	return false
+}
+
+// tableDef reads the table definition of the model s.
+func tableDef(s interface{}) (*TableDef, error) {
+	t := reflect.TypeOf(s)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, errors.New("table definition needs a struct, got " + t.String())
+	}
+	ret := &TableDef{Name: GetMapTable(fieldName2ColName(t.Name()))}
+	indexes := map[string]*IndexDef{}
+	addIndex := func(tag, prefix, col string, unique bool) {
+		name := tag
+		if tag == "true" {
+			name = prefix + ret.Name + "_" + col
+		}
+		idx, ok := indexes[name]
+		if !ok {
+			idx = &IndexDef{Name: name, Unique: unique}
+			indexes[name] = idx
+			ret.Indexes = append(ret.Indexes, idx)
+		}
+		idx.Columns = append(idx.Columns, col)
+	}
+	for k := 0; k < t.NumField(); k++ {
+		ft := t.Field(k)
+		// ignore:"true" fields are columns the database fills, they are created too
+		if ft.Tag.Get("or") != "" || ft.PkgPath != "" {
+			continue
+		}
+		c := &ColumnDef{
+			Name:          columnName(ft),
+			GoType:        ft.Type,
+			SQLType:       ft.Tag.Get("type"),
+			Nullable:      isNullableType(ft.Type),
+			PrimaryKey:    ft.Tag.Get("pk") == "true",
+			AutoIncrement: ft.Tag.Get("ai") == "true",
+		}
+		if size := ft.Tag.Get("size"); size != "" {
+			n, err := strconv.Atoi(size)
+			if err != nil {
+				return nil, errors.New("invalid size tag of " + t.Name() + "." + ft.Name)
+			}
+			c.Size = n
+		}
+		if null := ft.Tag.Get("null"); null != "" {
+			c.Nullable = null == "true"
+		}
+		if c.PrimaryKey {
+			c.Nullable = false
+		}
+		if d, ok := ft.Tag.Lookup("default"); ok {
+			c.Default = &d
+		}
+		if ft.Tag.Get("ignore") == "true" && c.Default == nil && !c.Nullable {
+			// inserts leave the column out
+			return nil, errors.New("ignore:\"true\" field " + t.Name() + "." + ft.Name + " needs a default or null tag")
+		}
+		if tag := ft.Tag.Get("unique"); tag != "" && tag != "false" {
+			addIndex(tag, "uniq_", c.Name, true)
+		}
+		if tag := ft.Tag.Get("index"); tag != "" && tag != "false" {
+			addIndex(tag, "idx_", c.Name, false)
+		}
+		ret.Columns = append(ret.Columns, c)
+	}
+	return ret, nil
+}
+
+// sortedTables returns the registered tables in name order.
+func sortedTables(tables map[string]interface{}) []interface{} {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ret := make([]interface{}, len(names))
+	for k, name := range names {
+		ret[k] = tables[name]
+	}
+	return ret
+}
+
+func getIndexNames(tdx Tdx, tableName string) (map[string]bool, error) {
+	rows, err := tdx.Query("show index from " + tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	ret := map[string]bool{}
+	values := make([]interface{}, len(cols))
+	for rows.Next() {
+		var name sql.NullString
+		for k, c := range cols {
+			if c == "Key_name" {
+				values[k] = &name
+			} else {
+				values[k] = new(sql.RawBytes)
+			}
+		}
+		if err := rows.Scan(values...); err != nil {
+			return nil, err
+		}
+		ret[name.String] = true
+	}
+	return ret, rows.Err()
+}
+
+func tableExists(tdx Tdx, tableName string) (bool, error) {
+	rows, err := tdx.Query("show tables like ?", tableName)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	// _ is a wildcard of like
+	exists := false
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		exists = exists || name == tableName
+	}
+	return exists, rows.Err()
+}
+
+// migrateStatements returns the statements creating the table of s, or
+// adding the columns and indexes it lacks when it exists.
+func migrateStatements(tdx Tdx, d Dialect, s interface{}, create bool) ([]string, error) {
+	def, err := tableDef(s)
+	if err != nil {
+		return nil, err
+	}
+	exists, err := tableExists(tdx, def.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return d.CreateTable(def)
+	}
+	if create {
+		return nil, nil
+	}
+	cols, err := getColumns(tdx, def.Name)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(cols))
+	for _, c := range cols {
+		known[c] = true
+	}
+	ret := []string{}
+	for _, c := range def.Columns {
+		if known[c.Name] {
+			continue
+		}
+		q, err := d.AddColumn(def.Name, c)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, q)
+	}
+	indexes, err := getIndexNames(tdx, def.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range def.Indexes {
+		if !indexes[idx.Name] {
+			ret = append(ret, d.AddIndex(def.Name, idx))
+		}
+	}
+	return ret, nil
+}
+
+func migrateTables(tdx Tdx, d Dialect, tables map[string]interface{}, create bool) error {
+	for _, s := range sortedTables(tables) {
+		stmts, err := migrateStatements(tdx, d, s, create)
+		if err != nil {
+			return err
+		}
+		for _, q := range stmts {
+			if _, err := tdx.Exec(q); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CreateTables creates the tables registered with AddTable that do not
+// exist yet. DDL commits implicitly in MySQL, so there is no ORMTran version.
+func (o *ORM) CreateTables() error {
+	tdx, span := o.startOp("CreateTables", "", "")
+	err := migrateTables(tdx, o.hooks.getDialect(), o.tables, true)
+	span.end(-1, err)
+	return err
+}
+
+// AutoMigrate works like CreateTables and also adds the columns and indexes
+// missing from existing tables. It never drops or changes anything.
+func (o *ORM) AutoMigrate() error {
+	tdx, span := o.startOp("AutoMigrate", "", "")
+	err := migrateTables(tdx, o.hooks.getDialect(), o.tables, false)
+	span.end(-1, err)
+	return err
+}
+
+// ColumnMismatch is a column whose definition disagrees with its field.
+type ColumnMismatch struct {
+	Column  string
+	SQLType string
+	GoType  string
+}
+
+// SchemaDiff lists how a table differs from its model. Use Empty to tell
+// whether they match.
+type SchemaDiff struct {
+	Table        string
+	TableMissing bool
+	// MissingFields are columns the model has no field for.
+	MissingFields []string
+	// ExtraFields are fields the table has no column for.
+	ExtraFields []string
+	// TypeMismatches are columns whose values the field type can not hold.
+	TypeMismatches []ColumnMismatch
+	// NullMismatches are nullable columns mapped to types that can not hold NULL.
+	NullMismatches []ColumnMismatch
+	// PKMismatches are columns where the primary key and the pk tag disagree.
+	PKMismatches []ColumnMismatch
+}
+
+func (d *SchemaDiff) Empty() bool {
+	return !d.TableMissing && len(d.MissingFields) == 0 && len(d.ExtraFields) == 0 &&
+		len(d.TypeMismatches) == 0 && len(d.NullMismatches) == 0 && len(d.PKMismatches) == 0
+}
+
+func (d *SchemaDiff) String() string {
+	if d.TableMissing {
+		return d.Table + ": table missing"
+	}
+	parts := []string{}
+	for _, c := range d.MissingFields {
+		parts = append(parts, "missing field for "+c)
+	}
+	for _, f := range d.ExtraFields {
+		parts = append(parts, "no column for "+f)
+	}
+	for _, m := range d.TypeMismatches {
+		parts = append(parts, fmt.Sprintf("%s %s can not be read into %s", m.Column, m.SQLType, m.GoType))
+	}
+	for _, m := range d.NullMismatches {
+		parts = append(parts, fmt.Sprintf("%s is nullable but %s is not", m.Column, m.GoType))
+	}
+	for _, m := range d.PKMismatches {
+		parts = append(parts, m.Column+" primary key disagrees with the pk tag")
+	}
+	if len(parts) == 0 {
+		return d.Table + ": ok"
+	}
+	return d.Table + ": " + strings.Join(parts, "; ")
+}
+
+// sqlTypeClass groups the types of show columns by the Go values they hold.
+func sqlTypeClass(sqlType string) string {
+	t := strings.ToLower(sqlType)
+	if i := strings.IndexAny(t, "( "); i >= 0 {
+		t = t[:i]
+	}
+	switch t {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint", "year", "bit":
+		return "int"
+	case "float", "double", "real":
+		return "float"
+	case "decimal", "numeric":
+		return "decimal"
+	case "date", "datetime", "timestamp":
+		return "time"
+	case "json":
+		return "json"
+	}
+	return "text"
+}
+
+// goTypeCompatible tells whether a field of type t can hold the values of
+// a column of class. Strings, bytes and custom scanners take anything.
+func goTypeCompatible(t reflect.Type, class string) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType, nullTimeType, softDeleteType:
+		return class == "time"
+	case nullInt64Type, nullInt32Type, nullInt16Type, nullByteType, nullBoolType:
+		return class == "int"
+	case nullFloat64Type:
+		return class == "int" || class == "float" || class == "decimal"
+	case nullStringType, rawMessageType:
+		return true
+	}
+	if reflect.PtrTo(t).Implements(scannerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return class == "int"
+	case reflect.Float32, reflect.Float64:
+		return class == "int" || class == "float" || class == "decimal"
+	}
+	return true
+}
+
+// holdsNull tells whether a field of type t can be scanned from NULL.
+func holdsNull(t reflect.Type) bool {
+	if isNullableType(t) || reflect.PtrTo(t).Implements(scannerType) {
+		return true
+	}
+	return t.Kind() == reflect.Slice || t.Kind() == reflect.Interface
+}
+
+// diffTable compares the table of s to its fields.
+func diffTable(tdx Tdx, s interface{}) (*SchemaDiff, error) {
+	t := reflect.TypeOf(s)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	ret := &SchemaDiff{Table: GetMapTable(getTableName(s))}
+	exists, err := tableExists(tdx, ret.Table)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		ret.TableMissing = true
+		return ret, nil
+	}
+	infos, err := getColumnInfos(tdx, ret.Table)
+	if err != nil {
+		return nil, err
+	}
+	// by top level field, an embedded SoftDelete holds deleted_at
+	seen := map[int]bool{}
+	for _, c := range infos {
+		// ignore:"true" fields hold the columns the database fills
+		f, ok := fieldByColumn(t, c.Name)
+		if !ok || f.Tag.Get("or") != "" {
+			ret.MissingFields = append(ret.MissingFields, c.Name)
+			continue
+		}
+		seen[f.Index[0]] = true
+		m := ColumnMismatch{Column: c.Name, SQLType: c.Type, GoType: f.Type.String()}
+		if !goTypeCompatible(f.Type, sqlTypeClass(c.Type)) {
+			ret.TypeMismatches = append(ret.TypeMismatches, m)
+		}
+		if c.Nullable && !holdsNull(f.Type) {
+			ret.NullMismatches = append(ret.NullMismatches, m)
+		}
+		if (c.Key == "PRI") != (f.Tag.Get("pk") == "true") {
+			ret.PKMismatches = append(ret.PKMismatches, m)
+		}
+	}
+	for k := 0; k < t.NumField(); k++ {
+		f := t.Field(k)
+		if seen[k] || f.PkgPath != "" || f.Tag.Get("ignore") == "true" || f.Tag.Get("or") != "" {
+			continue
+		}
+		ret.ExtraFields = append(ret.ExtraFields, f.Name)
+	}
+	return ret, nil
+}
+
+// FieldNameOf returns the field name the ORM maps the column col to.
+func FieldNameOf(col string) string {
+	return colName2FieldName(col)
+}
+
+// ColumnNameOf returns the column name the ORM maps the field name to.
+func ColumnNameOf(field string) string {
+	return fieldName2ColName(field)
+}
+
+// ForeignKey is a column referencing a column of another table.
+type ForeignKey struct {
+	Column    string
+	RefTable  string
+	RefColumn string
+}
+
+// Tables lists the tables of the current database.
+func (o *ORM) Tables() ([]string, error) {
+	rows, err := o.tdx().Query("show tables")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return ret, err
+		}
+		ret = append(ret, name)
+	}
+	return ret, rows.Err()
+}
+
+// Columns describes the columns of table as show columns does.
+func (o *ORM) Columns(table string) ([]*ColumnInfo, error) {
+	return getColumnInfos(o.tdx(), table)
+}
+
+// ForeignKeys lists the foreign keys of table.
+func (o *ORM) ForeignKeys(table string) ([]*ForeignKey, error) {
+	rows, err := o.tdx().Query("select column_name, referenced_table_name, referenced_column_name"+
+		" from information_schema.key_column_usage"+
+		" where table_schema = database() and table_name = ? and referenced_table_name is not null"+
+		" order by ordinal_position", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := []*ForeignKey{}
+	for rows.Next() {
+		fk := &ForeignKey{}
+		if err := rows.Scan(&fk.Column, &fk.RefTable, &fk.RefColumn); err != nil {
+			return ret, err
+		}
+		ret = append(ret, fk)
+	}
+	return ret, rows.Err()
+}
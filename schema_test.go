@@ -0,0 +1,186 @@
+package orm
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+)
+
+type TestSchemaUser struct {
+	UserId    int64  `pk:"true" ai:"true"`
+	Email     string `size:"128" unique:"true"`
+	Name      string `size:"64" index:"idx_name_age" default:"''"`
+	Age       uint8  `index:"idx_name_age"`
+	Bio       *string
+	Score     sql.NullFloat64
+	Price     string            `type:"DECIMAL(10,2)"`
+	CreatedAt time.Time         `size:"3"`
+	Books     []*TestCursorBook `or:"has_many" table:"test_cursor_book"`
+	Tmp       string            `ignore:"true" default:"''"`
+}
+
+func TestCreateTables(t *testing.T) {
+	fdb := &fakeDB{}
+	o := newFakeORM(fdb)
+	o.AddTable(TestSchemaUser{})
+	if err := o.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+	want := "CREATE TABLE IF NOT EXISTS `test_schema_user` (\n" +
+		"  `user_id` BIGINT NOT NULL AUTO_INCREMENT,\n" +
+		"  `email` VARCHAR(128) NOT NULL,\n" +
+		"  `name` VARCHAR(64) NOT NULL DEFAULT '',\n" +
+		"  `age` TINYINT UNSIGNED NOT NULL,\n" +
+		"  `bio` VARCHAR(255) NULL,\n" +
+		"  `score` DOUBLE NULL,\n" +
+		"  `price` DECIMAL(10,2) NOT NULL,\n" +
+		"  `created_at` DATETIME(3) NOT NULL,\n" +
+		"  `tmp` VARCHAR(255) NOT NULL DEFAULT '',\n" +
+		"  PRIMARY KEY (`user_id`),\n" +
+		"  UNIQUE KEY `uniq_test_schema_user_email` (`email`),\n" +
+		"  KEY `idx_name_age` (`name`,`age`)\n" +
+		")"
+	if q := fdb.execQueries(); len(q) != 1 || q[0] != want {
+		t.Fatalf("unexpected statements %v", q)
+	}
+}
+
+type TestFilledItem struct {
+	ItemId    int64 `pk:"true" ai:"true"`
+	Name      string
+	CreatedAt time.Time `ignore:"true" default:"CURRENT_TIMESTAMP"`
+}
+
+func TestCreateTablesIgnoredColumn(t *testing.T) {
+	fdb := &fakeDB{}
+	o := newFakeORM(fdb)
+	o.AddTable(TestFilledItem{})
+	if err := o.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+	want := "CREATE TABLE IF NOT EXISTS `test_filled_item` (\n" +
+		"  `item_id` BIGINT NOT NULL AUTO_INCREMENT,\n" +
+		"  `name` VARCHAR(255) NOT NULL,\n" +
+		"  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+		"  PRIMARY KEY (`item_id`)\n" +
+		")"
+	if q := fdb.execQueries(); len(q) != 1 || q[0] != want {
+		t.Fatalf("the column of an ignored field should be created, got %v", q)
+	}
+}
+
+type TestUnfilledItem struct {
+	ItemId int64  `pk:"true" ai:"true"`
+	Tmp    string `ignore:"true"`
+	Note   string `ignore:"true" null:"true"`
+}
+
+func TestCreateTablesIgnoredColumnNeedsDefault(t *testing.T) {
+	fdb := &fakeDB{}
+	o := newFakeORM(fdb)
+	o.AddTable(TestUnfilledItem{})
+	if err := o.CreateTables(); err == nil || !strings.Contains(err.Error(), "TestUnfilledItem.Tmp") {
+		t.Fatalf("an ignored column inserts can not fill should be rejected, got %v", err)
+	}
+	if len(fdb.execs) != 0 {
+		t.Fatal("nothing should be created")
+	}
+}
+
+func TestAutoMigrate(t *testing.T) {
+	fdb := &fakeDB{onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+		switch {
+		case strings.HasPrefix(query, "show tables"):
+			return &fakeRows{cols: []string{"Tables_in_test"}, rows: [][]driver.Value{{"test_schema_user"}}}, nil
+		case strings.HasPrefix(query, "show columns"):
+			ret := &fakeRows{cols: []string{"Field", "Type", "Null", "Key", "Default", "Extra"}}
+			for _, c := range []string{"user_id", "email", "name", "bio", "score", "price", "created_at", "tmp"} {
+				ret.rows = append(ret.rows, []driver.Value{c, "", "", "", nil, ""})
+			}
+			return ret, nil
+		case strings.HasPrefix(query, "show index"):
+			return &fakeRows{cols: []string{"Table", "Non_unique", "Key_name"}, rows: [][]driver.Value{
+				{"test_schema_user", int64(0), "PRIMARY"},
+				{"test_schema_user", int64(0), "uniq_test_schema_user_email"},
+			}}, nil
+		}
+		return nil, nil
+	}}
+	o := newFakeORM(fdb)
+	o.AddTable(TestSchemaUser{})
+	if err := o.CreateTables(); err != nil || len(fdb.execs) != 0 {
+		t.Fatalf("existing table should be left alone, got %v %v", fdb.execQueries(), err)
+	}
+	if err := o.AutoMigrate(); err != nil {
+		t.Fatal(err)
+	}
+	q := fdb.execQueries()
+	if len(q) != 2 || q[0] != "ALTER TABLE `test_schema_user` ADD COLUMN `age` TINYINT UNSIGNED NOT NULL" ||
+		q[1] != "CREATE INDEX `idx_name_age` ON `test_schema_user` (`name`,`age`)" {
+		t.Fatalf("unexpected statements %v", q)
+	}
+}
+
+func TestCheckTables(t *testing.T) {
+	fdb := &fakeDB{onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+		switch {
+		case strings.HasPrefix(query, "show tables"):
+			if args[0] == "test_cursor_book" {
+				return nil, nil
+			}
+			return &fakeRows{cols: []string{"Tables_in_test"}, rows: [][]driver.Value{{"test_schema_user"}}}, nil
+		case strings.HasPrefix(query, "show columns"):
+			return &fakeRows{cols: []string{"Field", "Type", "Null", "Key", "Default", "Extra"}, rows: [][]driver.Value{
+				{"user_id", "bigint(20)", "NO", "PRI", nil, "auto_increment"},
+				{"email", "varchar(128)", "YES", "UNI", nil, ""},
+				{"name", "varchar(64)", "NO", "", "", ""},
+				{"age", "datetime", "NO", "", nil, ""},
+				{"bio", "text", "YES", "", nil, ""},
+				{"score", "double", "YES", "", nil, ""},
+				{"price", "decimal(10,2)", "NO", "", nil, ""},
+				{"legacy", "int(11)", "NO", "", nil, ""},
+				{"tmp", "varchar(16)", "YES", "", nil, ""},
+			}}, nil
+		}
+		return nil, nil
+	}}
+	o := newFakeORM(fdb)
+	o.AddTable(TestSchemaUser{})
+	o.AddTable(TestCursorBook{})
+	diffs, err := o.CheckTables()
+	if err != nil || len(diffs) != 2 {
+		t.Fatalf("unexpected diffs %v %v", diffs, err)
+	}
+	if !diffs[0].TableMissing || diffs[0].Table != "test_cursor_book" {
+		t.Fatalf("unexpected diff %v", diffs[0])
+	}
+	d := diffs[1]
+	if d.Empty() || len(d.MissingFields) != 1 || d.MissingFields[0] != "legacy" ||
+		len(d.ExtraFields) != 1 || d.ExtraFields[0] != "CreatedAt" ||
+		len(d.TypeMismatches) != 1 || d.TypeMismatches[0].Column != "age" ||
+		len(d.NullMismatches) != 2 || d.NullMismatches[0].Column != "email" || d.NullMismatches[1].Column != "tmp" ||
+		len(d.PKMismatches) != 0 {
+		t.Fatalf("unexpected diff %s", d)
+	}
+}
+
+func TestCheckTablesSoftDelete(t *testing.T) {
+	fdb := &fakeDB{onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+		if strings.HasPrefix(query, "show tables") {
+			return &fakeRows{cols: []string{"Tables_in_test"}, rows: [][]driver.Value{{"test_soft_user"}}}, nil
+		}
+		return &fakeRows{cols: []string{"Field", "Type", "Null", "Key", "Default", "Extra"}, rows: [][]driver.Value{
+			{"user_id", "bigint(20)", "NO", "PRI", nil, "auto_increment"},
+			{"name", "varchar(255)", "NO", "", nil, ""},
+			{"deleted_at", "datetime", "YES", "", nil, ""},
+		}}, nil
+	}}
+	o := newFakeORM(fdb)
+	o.AddTable(TestSoftUser{})
+	diffs, err := o.CheckTables()
+	if err != nil || len(diffs) != 1 || !diffs[0].Empty() {
+		t.Fatalf("the embedded SoftDelete should hold deleted_at, got %v %v", diffs, err)
+	}
+}
@@ -0,0 +1,112 @@
+package orm
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func singleColumn(values ...driver.Value) func(string, []driver.Value) (*fakeRows, error) {
+	return func(string, []driver.Value) (*fakeRows, error) {
+		ret := &fakeRows{cols: []string{"v"}}
+		for _, v := range values {
+			ret.rows = append(ret.rows, []driver.Value{v})
+		}
+		return ret, nil
+	}
+}
+
+func TestSelectScalarSlices(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	fdb := &fakeDB{}
+	o := newFakeORM(fdb)
+
+	fdb.onQuery = singleColumn(now, now.Add(time.Hour))
+	var times []time.Time
+	if err := o.Select(&times, "select created_at from t"); err != nil || len(times) != 2 || !times[0].Equal(now) {
+		t.Fatalf("unexpected times %v %v", times, err)
+	}
+
+	fdb.onQuery = singleColumn(int64(1), int64(2))
+	var ids []int32
+	if err := o.Select(&ids, "select id from t"); err != nil || len(ids) != 2 || ids[1] != 2 {
+		t.Fatalf("unexpected ids %v %v", ids, err)
+	}
+
+	fdb.onQuery = singleColumn([]byte("a"), nil)
+	var names []sql.NullString
+	if err := o.Select(&names, "select name from t"); err != nil || len(names) != 2 || names[1].Valid {
+		t.Fatalf("unexpected names %v %v", names, err)
+	}
+
+	fdb.onQuery = singleColumn([]byte(`{"a":1}`))
+	var docs []json.RawMessage
+	if err := o.Select(&docs, "select doc from t"); err != nil || len(docs) != 1 || string(docs[0]) != `{"a":1}` {
+		t.Fatalf("unexpected docs %v %v", docs, err)
+	}
+}
+
+func TestSelectValueStructsWithRelations(t *testing.T) {
+	o := newFakeORM(&fakeDB{onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+		if strings.Contains(query, "test_cursor_book") {
+			return &fakeRows{
+				cols: []string{"book_id", "user_id", "title"},
+				rows: [][]driver.Value{{int64(1), int64(2), []byte("a")}},
+			}, nil
+		}
+		return cursorUsers(3), nil
+	}})
+	var users []TestCursorUser
+	if err := o.Select(&users, "select * from test_cursor_user"); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 3 || len(users[1].Books) != 1 || len(users[0].Books) != 0 {
+		t.Fatalf("unexpected users %+v", users)
+	}
+}
+
+func TestSelectMap(t *testing.T) {
+	fdb := &fakeDB{onQuery: func(string, []driver.Value) (*fakeRows, error) {
+		return cursorUsers(3), nil
+	}}
+	o := newFakeORM(fdb)
+
+	var byId map[int64]*TestCursorUser
+	if err := o.SelectMap(&byId, "select * from test_cursor_user"); err != nil {
+		t.Fatal(err)
+	}
+	if len(byId) != 3 || byId[2].UserId != 2 {
+		t.Fatalf("unexpected map %v", byId)
+	}
+
+	values := map[int]TestCursorBook{}
+	fdb.onQuery = func(string, []driver.Value) (*fakeRows, error) {
+		return &fakeRows{cols: []string{"book_id", "title"}, rows: [][]driver.Value{{int64(7), []byte("x")}}}, nil
+	}
+	if err := o.SelectMap(&values, "select book_id, title from test_cursor_book"); err != nil || values[7].Title != "x" {
+		t.Fatalf("unexpected map %v %v", values, err)
+	}
+
+	byName := map[string]*TestCursorUser{}
+	fdb.onQuery = func(string, []driver.Value) (*fakeRows, error) {
+		return cursorUsers(2), nil
+	}
+	if err := o.SelectMap(&byName, "select * from test_cursor_user"); err != nil || byName["2"] == nil {
+		t.Fatalf("integer keys should be formatted, got %v %v", byName, err)
+	}
+	byFloat := map[float64]*TestCursorUser{}
+	if err := o.SelectMap(&byFloat, "select * from test_cursor_user"); err == nil {
+		t.Fatal("a mismatched key type should be rejected")
+	}
+
+	fdb.onQuery = func(string, []driver.Value) (*fakeRows, error) {
+		return &fakeRows{cols: []string{"name", "cnt"}, rows: [][]driver.Value{{[]byte("a"), int64(3)}}}, nil
+	}
+	counts := map[string]int{}
+	if err := o.SelectMap(&counts, "select name, count(*) from t group by name"); err != nil || counts["a"] != 3 {
+		t.Fatalf("unexpected counts %v %v", counts, err)
+	}
+}
@@ -0,0 +1,146 @@
+package orm
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// SoftDelete is embedded in models whose rows Delete only marks deleted,
+// by setting their deleted_at column. A sql.NullTime or *time.Time field
+// tagged softdelete:"true" does the same with a column of its own.
+//
+// SelectByPK, FindInBatches and the loading of relations leave the deleted
+// rows out; queries written by hand have to do it themselves. Unscoped
+// returns an ORM seeing them, whose Delete removes rows for good.
+type SoftDelete struct {
+	DeletedAt sql.NullTime
+}
+
+// Value writes the embedded SoftDelete as the deleted_at column.
+func (d SoftDelete) Value() (driver.Value, error) {
+	return d.DeletedAt.Value()
+}
+
+var softDeleteType = reflect.TypeOf(SoftDelete{})
+
+// softDeleteField returns the column marking the rows of t deleted and the
+// index of the field holding the time.
+func softDeleteField(t reflect.Type) (string, []int, bool) {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return "", nil, false
+	}
+	for k := 0; k < t.NumField(); k++ {
+		ft := t.Field(k)
+		if ft.Anonymous && ft.Type == softDeleteType {
+			return columnName(ft), []int{k, 0}, true
+		}
+		if ft.Tag.Get("softdelete") == "true" {
+			return columnName(ft), ft.Index, true
+		}
+	}
+	return "", nil, false
+}
+
+// unscopedTdx carries Unscoped down to the statements the ORM writes.
+type unscopedTdx struct {
+	Tdx
+}
+
+func isUnscoped(tdx Tdx) bool {
+	_, ok := tdx.(unscopedTdx)
+	return ok
+}
+
+func scope(tdx Tdx, unscoped bool) Tdx {
+	if unscoped {
+		return unscopedTdx{tdx}
+	}
+	return tdx
+}
+
+// notDeleted returns the condition leaving the deleted rows of t out, to
+// append to a where clause.
+func notDeleted(tdx Tdx, t reflect.Type) string {
+	if isUnscoped(tdx) {
+		return ""
+	}
+	if col, _, ok := softDeleteField(t); ok {
+		return " and " + col + " is null"
+	}
+	return ""
+}
+
+// Unscoped returns a copy of o which sees deleted rows and whose Delete
+// removes rows for good.
+func (o *ORM) Unscoped() *ORM {
+	ret := *o
+	ret.unscoped = true
+	return &ret
+}
+
+// Unscoped returns a copy of o which sees deleted rows and whose Delete
+// removes rows for good.
+func (o *ORMTran) Unscoped() *ORMTran {
+	ret := *o
+	ret.unscoped = true
+	return &ret
+}
+
+// deleteRow deletes the row of the model s, or marks it deleted when s has
+// a soft delete field.
+func deleteRow(tdx Tdx, h *queryHooks, s interface{}) error {
+	if err := beforeDelete(tdx, s); err != nil {
+		return err
+	}
+	v := reflect.ValueOf(s).Elem()
+	table := GetMapTable(getTableName(s))
+	pkField, ok := getPkFieldByType(v.Type())
+	if !ok {
+		return errors.New(table + " does not have primary key")
+	}
+	pk := v.FieldByIndex(pkField.Index).Interface()
+	where := columnName(pkField) + " = ?"
+	var err error
+	if col, idx, ok := softDeleteField(v.Type()); ok && !isUnscoped(tdx) {
+		now := h.now()
+		err = updateRow(tdx, s, table, col+" = ?", []interface{}{now}, where, pk)
+		if err == nil {
+			setTime(v.FieldByIndex(idx), now)
+		}
+	} else if mv := getVersion(s); mv != nil {
+		err = mv.execVersioned(tdx, table, fmt.Sprintf("delete from %s where %s and %s = ?", table, where, mv.col), pk, mv.cur)
+	} else {
+		_, err = tdx.Exec(fmt.Sprintf("delete from %s where %s", table, where), pk)
+	}
+	if err != nil {
+		return err
+	}
+	return afterDelete(tdx, s)
+}
+
+// restore clears the deletion mark of the model s.
+func restore(tdx Tdx, s interface{}) error {
+	v := reflect.ValueOf(s).Elem()
+	table := GetMapTable(getTableName(s))
+	col, idx, ok := softDeleteField(v.Type())
+	if !ok {
+		return errors.New(table + " does not have a soft delete field")
+	}
+	pkField, ok := getPkFieldByType(v.Type())
+	if !ok {
+		return errors.New(table + " does not have primary key")
+	}
+	pk := v.FieldByIndex(pkField.Index).Interface()
+	if err := updateRow(tdx, s, table, col+" = null", nil, columnName(pkField)+" = ?", pk); err != nil {
+		return err
+	}
+	f := v.FieldByIndex(idx)
+	f.Set(reflect.Zero(f.Type()))
+	return nil
+}
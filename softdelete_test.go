@@ -0,0 +1,85 @@
+package orm
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+type TestSoftUser struct {
+	UserId int64 `pk:"true" ai:"true"`
+	Name   string
+	SoftDelete
+	Books []*TestSoftBook `or:"has_many" table:"test_soft_book"`
+}
+
+type TestSoftBook struct {
+	BookId  int64 `pk:"true"`
+	UserId  int64
+	Removed *time.Time `softdelete:"true"`
+}
+
+func TestSoftDelete(t *testing.T) {
+	fdb := &fakeDB{onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+		if len(args) == 0 || args[0] != int64(1) {
+			return &fakeRows{cols: []string{"book_id", "user_id", "removed"}}, nil
+		}
+		return &fakeRows{cols: []string{"user_id", "name", "deleted_at"}, rows: [][]driver.Value{{int64(1), []byte("a"), nil}}}, nil
+	}, onExec: func(query string, args []driver.Value) (driver.Result, error) {
+		return fakeInsertResult{lastInsertId: 2, rowsAffected: 1}, nil
+	}}
+	o := newFakeORM(fdb)
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	o.SetClock(func() time.Time { return now })
+
+	u := &TestSoftUser{}
+	if err := o.SelectByPK(u, int64(1)); err != nil {
+		t.Fatal(err)
+	}
+	if q := fdb.queries[0].query; q != "select * from test_soft_user where user_id = ? and deleted_at is null" {
+		t.Fatalf("unexpected query %s", q)
+	}
+	if q := fdb.queries[1].query; q != "SELECT * FROM test_soft_book WHERE user_id = ? and removed is null" {
+		t.Fatalf("relations should leave deleted rows out, got %s", q)
+	}
+
+	if err := o.Unscoped().SelectByPK(u, int64(1)); err != nil {
+		t.Fatal(err)
+	}
+	if q := fdb.queries[2].query; q != "select * from test_soft_user where user_id = ?" {
+		t.Fatalf("unscoped should see deleted rows, got %s", q)
+	}
+
+	if err := o.Delete(u); err != nil {
+		t.Fatal(err)
+	}
+	if q := fdb.execs[0].query; q != "update test_soft_user set deleted_at = ? where user_id = ?" || !u.DeletedAt.Time.Equal(now) {
+		t.Fatalf("delete should mark the row, got %s", q)
+	}
+	if err := o.Restore(u); err != nil {
+		t.Fatal(err)
+	}
+	if q := fdb.execs[1].query; q != "update test_soft_user set deleted_at = null where user_id = ?" || u.DeletedAt.Valid {
+		t.Fatalf("restore should clear the mark, got %s", q)
+	}
+	if err := o.Unscoped().Delete(u); err != nil {
+		t.Fatal(err)
+	}
+	if q := fdb.execs[2].query; q != "delete from test_soft_user where user_id = ?" {
+		t.Fatalf("unscoped delete should remove the row, got %s", q)
+	}
+
+	if err := o.Insert(&TestSoftUser{Name: "b"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if args := fdb.execs[3].args; fdb.execs[3].query != "insert into test_soft_user (name,deleted_at) values(?,?)" || args[1] != nil {
+		t.Fatalf("unexpected insert %s %v", fdb.execs[3].query, args)
+	}
+	// a matching deleted row stays deleted
+	if err := o.Upsert(&TestSoftUser{Name: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if q := fdb.execs[4].query; q != "insert into test_soft_user (name,deleted_at) values(?,?) on duplicate key update name = values(name),user_id = last_insert_id(user_id)" {
+		t.Fatalf("upsert should keep the deletion mark, got %s", q)
+	}
+}
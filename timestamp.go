@@ -0,0 +1,97 @@
+package orm
+
+import (
+	"database/sql"
+	"reflect"
+	"time"
+)
+
+// Fields tagged auto:"create_time" or auto:"update_time", and fields named
+// CreatedAt or UpdatedAt, are set by Insert, InsertBatch, Update and Upsert
+// from the clock of the ORM. They can be time.Time, *time.Time,
+// sql.NullTime or an integer holding unix seconds. A field tagged
+// ignore:"true" is left to the database.
+const (
+	autoCreateTime = "create_time"
+	autoUpdateTime = "update_time"
+)
+
+// Clock returns the time written to the timestamp fields.
+type Clock func() time.Time
+
+// SetClock changes the clock of the timestamp fields, time.Now by default.
+func (o *ORM) SetClock(c Clock) {
+	o.hooks.clock = c
+}
+
+func (h *queryHooks) now() time.Time {
+	if h.clock != nil {
+		return h.clock()
+	}
+	return time.Now()
+}
+
+// autoTime returns the auto tag of ft, "" for fields the ORM does not set.
+func autoTime(ft reflect.StructField) string {
+	if ft.Tag.Get("ignore") == "true" || ft.Tag.Get("or") != "" {
+		return ""
+	}
+	auto := ft.Tag.Get("auto")
+	if auto == "" {
+		switch ft.Name {
+		case "CreatedAt":
+			auto = autoCreateTime
+		case "UpdatedAt":
+			auto = autoUpdateTime
+		}
+	}
+	if auto != autoCreateTime && auto != autoUpdateTime || !isTimeField(ft.Type) {
+		return ""
+	}
+	return auto
+}
+
+func isTimeField(t reflect.Type) bool {
+	switch t {
+	case timeType, nullTimeType, reflect.PtrTo(timeType):
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func setTime(v reflect.Value, now time.Time) {
+	switch v.Type() {
+	case timeType:
+		v.Set(reflect.ValueOf(now))
+	case nullTimeType:
+		v.Set(reflect.ValueOf(sql.NullTime{Time: now, Valid: true}))
+	case reflect.PtrTo(timeType):
+		v.Set(reflect.ValueOf(&now))
+	default:
+		if v.CanInt() {
+			v.SetInt(now.Unix())
+		} else {
+			v.SetUint(uint64(now.Unix()))
+		}
+	}
+}
+
+// touchTimes sets the timestamp fields of the model s to now: the zero ones
+// for an insert, the update_time ones otherwise.
+func touchTimes(s interface{}, now time.Time, insert bool) {
+	v := reflect.ValueOf(s).Elem()
+	t := v.Type()
+	for k := 0; k < t.NumField(); k++ {
+		auto := autoTime(t.Field(k))
+		if auto == "" {
+			continue
+		}
+		if f := v.Field(k); insert && f.IsZero() || !insert && auto == autoUpdateTime {
+			setTime(f, now)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package orm
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+type TestStampUser struct {
+	UserId    int64 `pk:"true" ai:"true"`
+	Email     string
+	CreatedAt time.Time
+	UpdatedAt sql.NullTime
+	SeenAt    int64     `auto:"update_time"`
+	DbTime    time.Time `ignore:"true" auto:"create_time"`
+}
+
+func TestTimestamps(t *testing.T) {
+	fdb := &fakeDB{onExec: func(query string, args []driver.Value) (driver.Result, error) {
+		return fakeInsertResult{lastInsertId: 3, rowsAffected: 1}, nil
+	}}
+	o := newFakeORM(fdb)
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	o.SetClock(func() time.Time { return now })
+
+	u := &TestStampUser{Email: "a@b.c"}
+	if err := o.Insert(u, false); err != nil {
+		t.Fatal(err)
+	}
+	if !u.CreatedAt.Equal(now) || !u.UpdatedAt.Time.Equal(now) || u.SeenAt != now.Unix() || !u.DbTime.IsZero() {
+		t.Fatalf("insert should set the timestamps, got %+v", u)
+	}
+	if args := fdb.execs[0].args; len(args) != 4 || args[1] != now {
+		t.Fatalf("the timestamps should be written, got %v", args)
+	}
+
+	created := u.CreatedAt
+	now = now.Add(time.Hour)
+	if err := o.Update(u); err != nil {
+		t.Fatal(err)
+	}
+	if q := fdb.execs[1].query; q != "update test_stamp_user set email = ?,updated_at = ?,seen_at = ? where user_id = ?" {
+		t.Fatalf("unexpected update %s", q)
+	}
+	if !u.CreatedAt.Equal(created) || !u.UpdatedAt.Time.Equal(now) {
+		t.Fatalf("update should only touch the update time, got %+v", u)
+	}
+
+	v := &TestStampUser{Email: "a@b.c"}
+	if err := o.Upsert(v); err != nil {
+		t.Fatal(err)
+	}
+	if q := fdb.execs[2].query; q != "insert into test_stamp_user (email,created_at,updated_at,seen_at) values(?,?,?,?) on duplicate key update email = values(email),updated_at = values(updated_at),seen_at = values(seen_at),user_id = last_insert_id(user_id)" {
+		t.Fatalf("unexpected upsert %s", q)
+	}
+	if v.UserId != 3 || !v.CreatedAt.Equal(now) {
+		t.Fatalf("an inserting upsert should set the id and timestamps, got %+v", v)
+	}
+}
@@ -0,0 +1,184 @@
+package orm
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"sync"
+)
+
+// Attribute is a key/value pair attached to a span.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Span is the subset of an OpenTelemetry span the ORM uses.
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// Tracer starts spans, the returned context must carry the new span so
+// that spans started from it nest below. An OpenTelemetry trace.Tracer can
+// be adapted with a few lines.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Attribute keys, following the OpenTelemetry database conventions.
+const (
+	AttrDBSystem    = "db.system"
+	AttrDBStatement = "db.statement"
+	AttrDBOperation = "db.operation"
+	AttrDBTable     = "db.sql.table"
+	AttrDBRows      = "db.response.returned_rows"
+)
+
+// SetTracer makes every ORM call and each statement it sends emit a span,
+// nil disables tracing.
+func (o *ORM) SetTracer(t Tracer) {
+	o.hooks.tracer = t
+}
+
+// opSpan is the span of one ORM call, a nil *opSpan does nothing.
+type opSpan struct {
+	span Span
+}
+
+// startOp returns the Tdx an ORM call named name should use and its span.
+func (h *queryHooks) startOp(ctx context.Context, raw rawTdx, name, table, query string) (Tdx, *opSpan) {
+	if h == nil || h.tracer == nil {
+		return h.wrap(ctx, raw), nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := h.tracer.Start(ctx, "orm."+name)
+	attrs := []Attribute{{AttrDBSystem, "mysql"}}
+	if table != "" {
+		attrs = append(attrs, Attribute{AttrDBTable, table})
+	}
+	if query != "" {
+		attrs = append(attrs, Attribute{AttrDBStatement, query})
+	}
+	span.SetAttributes(attrs...)
+	return h.wrap(ctx, raw), &opSpan{span: span}
+}
+
+// end finishes the span, rows < 0 means the count is unknown.
+func (s *opSpan) end(rows int64, err error) {
+	if s == nil {
+		return
+	}
+	if rows >= 0 {
+		s.span.SetAttributes(Attribute{AttrDBRows, rows})
+	}
+	if err != nil {
+		s.span.RecordError(err)
+	}
+	s.span.End()
+}
+
+// startStatement starts the span of one statement sent to the database.
+func (h *queryHooks) startStatement(ctx context.Context, query string) (context.Context, *opSpan) {
+	if h.tracer == nil {
+		return ctx, nil
+	}
+	op := sqlOperation(query)
+	table := sqlTableName(query)
+	ctx, span := h.tracer.Start(ctx, op+" "+table)
+	attrs := []Attribute{{AttrDBSystem, "mysql"}, {AttrDBStatement, query}, {AttrDBOperation, op}}
+	if table != "" {
+		attrs = append(attrs, Attribute{AttrDBTable, table})
+	}
+	span.SetAttributes(attrs...)
+	return ctx, &opSpan{span: span}
+}
+
+func rowsAffected(ret sql.Result) int64 {
+	if ret == nil {
+		return -1
+	}
+	n, err := ret.RowsAffected()
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// oneRow is the row count of an operation on one row.
+func oneRow(err error) int64 {
+	if err != nil {
+		return 0
+	}
+	return 1
+}
+
+func resultRows(s interface{}) int64 {
+	v := reflect.Indirect(reflect.ValueOf(s))
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Map {
+		return int64(v.Len())
+	}
+	return 1
+}
+
+// RecordedSpan is a span captured by a SpanRecorder.
+type RecordedSpan struct {
+	Name       string
+	Parent     *RecordedSpan
+	Attributes map[string]interface{}
+	Err        error
+	Ended      bool
+	recorder   *SpanRecorder
+}
+
+func (s *RecordedSpan) SetAttributes(attrs ...Attribute) {
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	for _, a := range attrs {
+		s.Attributes[a.Key] = a.Value
+	}
+}
+
+func (s *RecordedSpan) RecordError(err error) {
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	s.Err = err
+}
+
+func (s *RecordedSpan) End() {
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	s.Ended = true
+}
+
+type recordedSpanKey struct{}
+
+// SpanRecorder is an in-memory Tracer, mainly meant for tests.
+type SpanRecorder struct {
+	mu    sync.Mutex
+	spans []*RecordedSpan
+}
+
+func (r *SpanRecorder) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(recordedSpanKey{}).(*RecordedSpan)
+	s := &RecordedSpan{
+		Name:       name,
+		Parent:     parent,
+		Attributes: make(map[string]interface{}),
+		recorder:   r,
+	}
+	r.mu.Lock()
+	r.spans = append(r.spans, s)
+	r.mu.Unlock()
+	return context.WithValue(ctx, recordedSpanKey{}, s), s
+}
+
+// Spans returns the recorded spans in start order.
+func (r *SpanRecorder) Spans() []*RecordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*RecordedSpan(nil), r.spans...)
+}
@@ -0,0 +1,108 @@
+package orm
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestTracingSpansNest(t *testing.T) {
+	rec := &SpanRecorder{}
+	hooks := &queryHooks{tracer: rec}
+	ctx, parent := rec.Start(context.Background(), "handler")
+
+	fdb := &fakeDB{onExec: func(string, []driver.Value) (driver.Result, error) {
+		return driver.RowsAffected(2), nil
+	}}
+	tdx, span := hooks.startOp(ctx, fdb.open(), "Exec", "", "update user set a = 1")
+	ret, err := tdx.Exec("update user set a = 1")
+	span.end(rowsAffected(ret), err)
+	parent.End()
+
+	spans := rec.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+	op, stmt := spans[1], spans[2]
+	if op.Name != "orm.Exec" || op.Parent != spans[0] || !op.Ended {
+		t.Fatalf("incorrect operation span %+v", op)
+	}
+	if stmt.Name != "update user" || stmt.Parent != op || !stmt.Ended {
+		t.Fatalf("incorrect statement span %+v", stmt)
+	}
+	if stmt.Attributes[AttrDBSystem] != "mysql" || stmt.Attributes[AttrDBTable] != "user" ||
+		stmt.Attributes[AttrDBStatement] != "update user set a = 1" || op.Attributes[AttrDBRows] != int64(2) {
+		t.Fatalf("incorrect attributes %v %v", op.Attributes, stmt.Attributes)
+	}
+}
+
+func TestNoTracerNoSpan(t *testing.T) {
+	tdx, span := (&queryHooks{}).startOp(nil, (&fakeDB{}).open(), "Exec", "", "select 1")
+	if span != nil {
+		t.Fatal("span should be nil without a tracer")
+	}
+	span.end(1, nil)
+	if _, ok := tdx.(*hookedTdx); ok {
+		t.Fatal("tdx should not go through the hooks without any")
+	}
+}
+
+func TestSpanRows(t *testing.T) {
+	rec := &SpanRecorder{}
+	o := newFakeORM(&fakeDB{onExec: func(string, []driver.Value) (driver.Result, error) {
+		return driver.RowsAffected(3), nil
+	}})
+	o.SetTracer(rec)
+
+	u := &TestCursorUser{}
+	if err := o.SelectOne(u, "select * from test_cursor_user where user_id = ?", 1); err == nil {
+		t.Fatal("expected no rows")
+	}
+	if err := o.ExecWithRowAffectCheck(1, "update test_cursor_user set name = ''"); err == nil {
+		t.Fatal("expected a row affect error")
+	}
+	var ops []*RecordedSpan
+	for _, s := range rec.Spans() {
+		if strings.HasPrefix(s.Name, "orm.") {
+			ops = append(ops, s)
+		}
+	}
+	if len(ops) != 2 || ops[0].Attributes[AttrDBRows] != int64(0) || ops[1].Attributes[AttrDBRows] != int64(3) {
+		t.Fatalf("spans should hold the real row counts, got %v", ops)
+	}
+}
+
+func TestTracingRelationSpans(t *testing.T) {
+	rec := &SpanRecorder{}
+	o := newFakeORM(relationDB())
+	o.SetTracer(rec)
+
+	u := &TestSoftUser{}
+	if err := o.SelectOne(u, "select * from test_soft_user where user_id = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := o.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.SelectByPK(u, 1); err != nil {
+		t.Fatal(err)
+	}
+	tx.Commit()
+
+	var ops, books []*RecordedSpan
+	for _, s := range rec.Spans() {
+		if strings.HasPrefix(s.Name, "orm.") {
+			ops = append(ops, s)
+		} else if s.Attributes[AttrDBTable] == "test_soft_book" {
+			books = append(books, s)
+		}
+	}
+	if len(ops) != 2 || ops[0].Name != "orm.SelectOne" || ops[1].Name != "orm.SelectByPK" {
+		t.Fatalf("unexpected operation spans %v", ops)
+	}
+	if len(books) != 2 || books[0].Parent != ops[0] || books[1].Parent != ops[1] {
+		t.Fatalf("relation queries should nest below their call, got %v", books)
+	}
+}
@@ -0,0 +1,86 @@
+package orm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrStaleObject is returned by Update, Delete and Restore of a model with
+// a version:"true" integer field when its row was changed, or removed,
+// since the model was read. Insert starts the version at 1 and every write
+// through the model increments it.
+var ErrStaleObject = errors.New("stale object")
+
+// modelVersion is the version field of a model being written.
+type modelVersion struct {
+	field reflect.Value
+	col   string
+	cur   int64
+}
+
+// getVersion returns the version field of the model s, nil when it has none.
+func getVersion(s interface{}) *modelVersion {
+	v := reflect.ValueOf(s).Elem()
+	t := v.Type()
+	for k := 0; k < t.NumField(); k++ {
+		ft := t.Field(k)
+		if ft.Tag.Get("version") != "true" {
+			continue
+		}
+		mv := &modelVersion{field: v.Field(k), col: columnName(ft)}
+		if mv.field.CanInt() {
+			mv.cur = mv.field.Int()
+		} else {
+			mv.cur = int64(mv.field.Uint())
+		}
+		return mv
+	}
+	return nil
+}
+
+func (mv *modelVersion) set(n int64) {
+	if mv.field.CanInt() {
+		mv.field.SetInt(n)
+	} else {
+		mv.field.SetUint(uint64(n))
+	}
+}
+
+// initVersion starts the version of the model s at 1 for an insert.
+func initVersion(s interface{}) {
+	if mv := getVersion(s); mv != nil && mv.cur == 0 {
+		mv.set(1)
+	}
+}
+
+// execVersioned runs query, which matches the row of the model by its
+// version, and reports a missed row as ErrStaleObject.
+func (mv *modelVersion) execVersioned(tdx Tdx, table string, query string, args ...interface{}) error {
+	err := execWithRowAffectCheck(tdx, 1, query, args...)
+	if err != nil && IsRowAffectError(err) {
+		return fmt.Errorf("%w: %s at version %d", ErrStaleObject, table, mv.cur)
+	}
+	return err
+}
+
+// updateRow runs "update table set <set> where <where>" for the model s.
+// The version of a versioned model is matched and incremented.
+func updateRow(tdx Tdx, s interface{}, table string, set string, setArgs []interface{}, where string, whereArgs ...interface{}) error {
+	mv := getVersion(s)
+	if mv == nil {
+		_, err := tdx.Exec(fmt.Sprintf("update %s set %s where %s", table, set, where), append(setArgs, whereArgs...)...)
+		return err
+	}
+	if set != "" {
+		set += ","
+	}
+	set += mv.col + " = ?"
+	where += " and " + mv.col + " = ?"
+	args := append(append(setArgs, mv.cur+1), append(whereArgs, mv.cur)...)
+	if err := mv.execVersioned(tdx, table, fmt.Sprintf("update %s set %s where %s", table, set, where), args...); err != nil {
+		return err
+	}
+	mv.set(mv.cur + 1)
+	return nil
+}
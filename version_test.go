@@ -0,0 +1,88 @@
+package orm
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type TestVersionDoc struct {
+	DocId   int64 `pk:"true" ai:"true"`
+	Title   string
+	Version int32 `version:"true"`
+}
+
+func TestOptimisticLocking(t *testing.T) {
+	var affected int64 = 1
+	fdb := &fakeDB{onExec: func(query string, args []driver.Value) (driver.Result, error) {
+		return fakeInsertResult{lastInsertId: 4, rowsAffected: affected}, nil
+	}}
+	o := newFakeORM(fdb)
+
+	d := &TestVersionDoc{Title: "a"}
+	if err := o.Insert(d, false); err != nil {
+		t.Fatal(err)
+	}
+	if d.Version != 1 || fdb.execs[0].args[1] != int64(1) {
+		t.Fatalf("insert should start the version at 1, got %d", d.Version)
+	}
+
+	if err := o.Update(d); err != nil {
+		t.Fatal(err)
+	}
+	if q := fdb.execs[1].query; q != "update test_version_doc set title = ?,version = ? where doc_id = ? and version = ?" {
+		t.Fatalf("unexpected update %s", q)
+	}
+	if args := fdb.execs[1].args; args[1] != int64(2) || args[3] != int64(1) || d.Version != 2 {
+		t.Fatalf("update should match and increment the version, got %v", args)
+	}
+
+	affected = 0
+	if err := o.Update(d); !errors.Is(err, ErrStaleObject) || d.Version != 2 {
+		t.Fatalf("a missed version should be ErrStaleObject, got %v", err)
+	}
+	if err := o.Delete(d); !errors.Is(err, ErrStaleObject) {
+		t.Fatalf("a missed version should be ErrStaleObject, got %v", err)
+	}
+	if q := fdb.execs[3].query; q != "delete from test_version_doc where doc_id = ? and version = ?" {
+		t.Fatalf("unexpected delete %s", q)
+	}
+}
+
+func TestUpsertReadsVersionBack(t *testing.T) {
+	fdb := &fakeDB{
+		onExec: func(query string, args []driver.Value) (driver.Result, error) {
+			if strings.HasPrefix(query, "insert") {
+				// the row of doc 7 existed at version 3 and was updated
+				return fakeInsertResult{lastInsertId: 7, rowsAffected: 2}, nil
+			}
+			return fakeInsertResult{rowsAffected: 1}, nil
+		},
+		onQuery: func(query string, args []driver.Value) (*fakeRows, error) {
+			return &fakeRows{cols: []string{"version"}, rows: [][]driver.Value{{int64(4)}}}, nil
+		},
+	}
+	o := newFakeORM(fdb)
+
+	d := &TestVersionDoc{Title: "a"}
+	if err := o.Upsert(d); err != nil {
+		t.Fatal(err)
+	}
+	if q := fdb.execs[0].query; !strings.HasSuffix(q, "version = version + 1,doc_id = last_insert_id(doc_id)") {
+		t.Fatalf("unexpected upsert %s", q)
+	}
+	if q := fdb.queries[0]; q.query != "select version from test_version_doc where doc_id = ?" || q.args[0] != int64(7) {
+		t.Fatalf("the version should be read back, got %s %v", q.query, q.args)
+	}
+	if d.DocId != 7 || d.Version != 4 {
+		t.Fatalf("the upserted doc should hold its row, got %+v", d)
+	}
+
+	if err := o.Update(d); err != nil {
+		t.Fatal(err)
+	}
+	if args := fdb.execs[1].args; args[1] != int64(5) || args[2] != int64(7) || args[3] != int64(4) {
+		t.Fatalf("update should match the version read back, got %v", args)
+	}
+}